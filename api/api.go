@@ -4,23 +4,33 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"slices"
+	"strconv"
 	"time"
 	"user-db/db"
 	"user-db/llm"
 	"user-db/questions"
+	"user-db/scoring"
 	"user-db/shared"
 )
 
 const HOLISTIC string = "holistic"
 const COOKIENAME string = "uid"
 
+// JOBS_PATH is the path of GetInsightJob, followed by the job id.
+const JOBS_PATH string = "/v1/insights/jobs/"
+
 func (s *Server) ResetUser(w http.ResponseWriter, r *http.Request) {
 	uid := getUid(r)
-	db.ResetUser(uid)
+	if err := s.Store.ResetUser(uid); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Failed trying to reset user (%s): %s", uid, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
@@ -36,7 +46,7 @@ func (s *Server) GetUserId(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error generating new user uid: %s", uid)
 			return
 		}
-		err = db.NewUser(uid)
+		err = s.Store.NewUser(uid)
 		if err != nil {
 			log.Printf("Not able to create user with ID: %s", uid)
 			return
@@ -62,20 +72,22 @@ func (s *Server) GetQuestions(w http.ResponseWriter, r *http.Request) {
 	uid := getUid(r)
 
 	// get answers from DB
-	userAnswer, err := db.GetUser(uid)
+	userAnswer, err := s.Store.GetUser(uid)
 	if err != nil {
 		log.Printf("error getting user (%s): %v", uid, err)
 	}
 
 	prioDimension := r.URL.Query().Get("dimension")
 
-	// Expected path structure: /v1/questions or /v1/questions/{dimension}
+	// Expected path structure: /v1/questions or /v1/questions?dimension={dimension name or slug}
 	if prioDimension != "" {
-		if !questions.IsValidDimension(prioDimension) {
-			http.Error(w, fmt.Sprintf("Invalid dimension: %s", prioDimension), http.StatusInternalServerError)
+		dimensionName, ok := questions.ResolveDimension(prioDimension)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Invalid dimension: %s", prioDimension), http.StatusBadRequest)
 			log.Printf("error, unknown dimension: %s", prioDimension)
 			return
 		}
+		prioDimension = dimensionName
 		log.Printf("Prioritised dimension: %s", prioDimension)
 	} else {
 		log.Printf("No prioritised dimension specified")
@@ -92,6 +104,10 @@ func (s *Server) GetQuestions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(nextQuestions)
 }
 
+func (s *Server) GetDimensions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, questions.GetCatalogue())
+}
+
 func (s *Server) SubmitResponses(w http.ResponseWriter, r *http.Request) {
 	var payload ResponsePayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -100,106 +116,212 @@ func (s *Server) SubmitResponses(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(payload.Answers) == 0 {
+		writeJSON(w, http.StatusBadRequest, SubmitResult{Success: false, Error: "no answers submitted"})
+		return
+	}
+
 	uid := getUid(r)
 
-	for _, answer := range payload.Answers {
-		kind, err := shared.ToAnswerKind(answer.Kind)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			log.Printf("Failed trying to convert answer kind: %s", err)
-			return
-		}
-		// TODO Insert Many. This is not atomic
-		err = db.UpsertAnswer(uid, answer.QuestionID, kind, answer.Value)
-		if err != nil {
-			log.Printf("Failed trying to upsert answer %d with error: %s", answer.QuestionID, err)
-		}
+	updates, answerErrs := validateAnswers(payload.Answers)
+	if len(answerErrs) > 0 {
+		writeJSON(w, http.StatusBadRequest, SubmitResult{Success: false, Errors: answerErrs})
+		log.Printf("Rejected %d of %d answers from user (%s)", len(answerErrs), len(payload.Answers), uid)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	ua, err := s.Store.UpsertAnswers(uid, updates)
+	if err != nil {
+		writeJSON(w, storeErrorStatus(err), SubmitResult{Success: false, Error: err.Error()})
+		log.Printf("Failed trying to upsert answers for user (%s): %s", uid, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SubmitResult{Success: true})
+
+	// ua is the state as committed by UpsertAnswers
+	s.queueInsights(uid, ua)
+}
+
+func (s *Server) GetScores(w http.ResponseWriter, r *http.Request) {
 
-	ua, err := db.GetUser(uid)
+	uid := getUid(r)
+
+	userAnswers, err := s.Store.GetUser(uid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Printf("Failed trying to get user: %s", err)
+		http.Error(w, err.Error(), storeErrorStatus(err))
+		log.Printf("error getting user (%s): %v", uid, err)
 		return
 	}
 
-	completeDims := questions.GetCompleteDimensions(ua)
+	scores := scoring.Compute(userAnswers, shared.MapToSlice(questions.GetQuestions()), questions.GetDimensions())
 
-	for _, dimensionName := range completeDims {
-		if ua.NeedsInsight(dimensionName) {
-			go func(userID, dimName string, uaCopy db.UserAnswers) {
-				err := db.UpsertInsight(userID, dimName, "", db.GENERATING)
-				if err != nil {
-					log.Printf("Failed trying to upsert insight: %s", err)
-					return
-				}
-				dimensionInsight := llm.DimensionPrompt(dimName, uaCopy.DimensionRatingsToString(dimName, questions.GetDimensions()))
-				err = db.UpsertInsight(userID, dimName, dimensionInsight, db.DONE)
-				if err != nil {
-					log.Printf("Failed trying to upsert insight: %s", err)
-					return
-				}
+	writeJSON(w, http.StatusOK, scores)
+}
+
+func (s *Server) GetProgress(w http.ResponseWriter, r *http.Request) {
+
+	uid := getUid(r)
+
+	userAnswers, err := s.Store.GetUser(uid)
+	if err != nil {
+		http.Error(w, err.Error(), storeErrorStatus(err))
+		log.Printf("error getting user (%s): %v", uid, err)
+		return
+	}
 
-				s.Broker.Publish(InsightEvent{
-					Name:   dimName,
-					UserID: userID,
-					Data:   dimensionInsight,
-				})
+	scores := scoring.Compute(userAnswers, shared.MapToSlice(questions.GetQuestions()), questions.GetDimensions())
 
-			}(uid, dimensionName, ua)
+	progress := Progress{
+		Dimensions:        []DimensionProgress{},
+		NextDimension:     questions.NextDimension(userAnswers),
+		HolisticStatus:    userAnswers.Insights[HOLISTIC].Status,
+		HolisticAvailable: userAnswers.HasGeneratedInsight(HOLISTIC),
+	}
+	for _, dim := range scores.Dimensions {
+		// DONTKNOW counts as answered
+		dimProgress := DimensionProgress{
+			Name:             dim.Name,
+			Slug:             shared.Slugify(dim.Name),
+			Answered:         dim.Answered + dim.DontKnow,
+			Total:            dim.Total,
+			Complete:         dim.Answered+dim.DontKnow == dim.Total,
+			SubDimensions:    []SubDimensionProgress{},
+			InsightStatus:    userAnswers.Insights[dim.Name].Status,
+			InsightAvailable: userAnswers.HasGeneratedInsight(dim.Name),
+		}
+		for _, subDim := range dim.SubDimensions {
+			dimProgress.SubDimensions = append(dimProgress.SubDimensions, SubDimensionProgress{
+				Name:     subDim.Name,
+				Answered: subDim.Answered + subDim.DontKnow,
+				Total:    subDim.Total,
+			})
 		}
+		progress.Dimensions = append(progress.Dimensions, dimProgress)
 	}
+
+	writeJSON(w, http.StatusOK, progress)
 }
 
-func (s *Server) GenerateHolistic(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetAnswerHistory(w http.ResponseWriter, r *http.Request) {
 
 	uid := getUid(r)
 
-	userAnswers, err := db.GetUser(uid)
+	questionID, err := strconv.Atoi(r.URL.Query().Get("questionId"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid questionId: %s", r.URL.Query().Get("questionId")), http.StatusBadRequest)
+		log.Printf("error, invalid questionId: %s", err)
+		return
+	}
+	if _, ok := questions.GetQuestions()[questionID]; !ok {
+		http.Error(w, fmt.Sprintf("Unknown questionId: %d", questionID), http.StatusBadRequest)
+		log.Printf("error, unknown questionId: %d", questionID)
+		return
+	}
+
+	userAnswers, err := s.Store.GetUser(uid)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Printf("error getting user (%s): %v", uid, err)
 		return
 	}
 
-	sortedDims, sortedFacets := userAnswers.GetSorted(questions.GetQuestions(), questions.GetDimensions())
-	resp := llm.HolisticPrompt(sortedDims, sortedFacets)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnswerHistory{
+		QuestionID: questionID,
+		History:    userAnswers.GetAnswerHistory(questionID),
+	})
+}
 
-	err = db.UpsertInsight(uid, HOLISTIC, resp, db.DONE)
-	if err != nil {
-		log.Printf("Failed trying to upsert insight: %s", err)
+// GenerateHolistic queues the holistic insight. Deprecated: use QueueHolistic,
+// which also returns the job.
+func (s *Server) GenerateHolistic(w http.ResponseWriter, r *http.Request) {
+
+	uid := getUid(r)
+
+	if _, err := s.Store.GetUser(uid); err != nil {
+		http.Error(w, err.Error(), storeErrorStatus(err))
+		log.Printf("error getting user (%s): %v", uid, err)
+		return
+	}
+
+	if _, err := s.enqueueInsight(uid, HOLISTIC, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
-	s.Broker.Publish(InsightEvent{
-		Name:   HOLISTIC,
-		UserID: uid,
-		Data:   resp,
-	})
+}
+
+// QueueHolistic queues the holistic insight and answers 202 Accepted with the
+// job, without waiting for the generation.
+func (s *Server) QueueHolistic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uid := getUid(r)
+
+	if _, err := s.Store.GetUser(uid); err != nil {
+		http.Error(w, err.Error(), storeErrorStatus(err))
+		log.Printf("error getting user (%s): %v", uid, err)
+		return
+	}
+
+	job, err := s.enqueueInsight(uid, HOLISTIC, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", JOBS_PATH+job.ID)
+	writeJSON(w, http.StatusAccepted, newInsightJob(job))
+}
+
+// GetInsightJob reports the status of an insight job of the user.
+func (s *Server) GetInsightJob(w http.ResponseWriter, r *http.Request) {
+
+	uid := getUid(r)
 
+	job, err := s.Queue.Job(r.PathValue("id"))
+	// jobs of other users are reported as missing
+	if err == nil && job.UserID != uid {
+		err = db.ErrJobNotFound
+	}
+	if errors.Is(err, db.ErrJobNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("error getting job (%s): %v", r.PathValue("id"), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newInsightJob(job))
 }
 
 func (s *Server) GetInsightsLLM(w http.ResponseWriter, r *http.Request) {
 
 	uid := getUid(r)
 
-	userAnswers, err := db.GetUser(uid)
+	userAnswers, err := s.Store.GetUser(uid)
 	if err != nil {
+		http.Error(w, err.Error(), storeErrorStatus(err))
 		log.Printf("error getting user (%s): %v", uid, err)
 		return
 	}
 
 	insights := map[string]string{}
 
-	if userAnswers.HasInsight(HOLISTIC) {
+	// the latest version is kept while an insight is regenerated
+	if userAnswers.HasGeneratedInsight(HOLISTIC) {
 		insights[HOLISTIC] = string(userAnswers.GetInsight(HOLISTIC))
 	}
 	for dimensionName := range questions.GetDimensions() {
-		if userAnswers.HasInsight(dimensionName) {
+		if userAnswers.HasGeneratedInsight(dimensionName) {
 			insights[dimensionName] = string(userAnswers.GetInsight(dimensionName))
 		}
 	}
@@ -207,6 +329,122 @@ func (s *Server) GetInsightsLLM(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(insights)
 }
 
+// GetInsights lists the holistic insight and the insights of every dimension,
+// including the ones not generated yet. ?dimension= limits the list to one
+// insight.
+func (s *Server) GetInsights(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	uid := getUid(r)
+
+	// dimensions without insights have none to list
+	names := []string{HOLISTIC}
+	for _, dimension := range questions.GetCatalogue() {
+		if dimension.Insights {
+			names = append(names, dimension.Name)
+		}
+	}
+	if dimension := r.URL.Query().Get("dimension"); dimension != "" {
+		insightName, ok := resolveInsightName(dimension)
+		if !ok || !slices.Contains(names, insightName) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid dimension: %s", dimension))
+			return
+		}
+		names = []string{insightName}
+	}
+
+	userAnswers, err := s.Store.GetUser(uid)
+	if err != nil {
+		writeError(w, storeErrorStatus(err), err.Error())
+		log.Printf("error getting user (%s): %v", uid, err)
+		return
+	}
+
+	list := InsightList{Insights: []InsightSummary{}}
+	for _, name := range names {
+		list.Insights = append(list.Insights, newInsightSummary(name, userAnswers.Insights[name]))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) GetInsightVersions(w http.ResponseWriter, r *http.Request) {
+
+	uid := getUid(r)
+
+	insightName, ok := resolveInsightName(r.URL.Query().Get("dimension"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid dimension: %s", r.URL.Query().Get("dimension")), http.StatusBadRequest)
+		log.Printf("error, unknown dimension: %s", r.URL.Query().Get("dimension"))
+		return
+	}
+
+	userAnswers, err := s.Store.GetUser(uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("error getting user (%s): %v", uid, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InsightVersions{
+		Name:     insightName,
+		Versions: userAnswers.GetInsightVersions(insightName),
+	})
+}
+
+func (s *Server) GetInsightDiff(w http.ResponseWriter, r *http.Request) {
+
+	uid := getUid(r)
+
+	query := r.URL.Query()
+	insightName, ok := resolveInsightName(query.Get("dimension"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid dimension: %s", query.Get("dimension")), http.StatusBadRequest)
+		log.Printf("error, unknown dimension: %s", query.Get("dimension"))
+		return
+	}
+	from, errFrom := strconv.Atoi(query.Get("from"))
+	to, errTo := strconv.Atoi(query.Get("to"))
+	if errFrom != nil || errTo != nil {
+		http.Error(w, "from and to must be version numbers", http.StatusBadRequest)
+		log.Printf("error, invalid versions: from=%s to=%s", query.Get("from"), query.Get("to"))
+		return
+	}
+
+	userAnswers, err := s.Store.GetUser(uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("error getting user (%s): %v", uid, err)
+		return
+	}
+
+	fromVersion, okFrom := userAnswers.GetInsightVersion(insightName, from)
+	toVersion, okTo := userAnswers.GetInsightVersion(insightName, to)
+	if !okFrom || !okTo {
+		http.Error(w, fmt.Sprintf("Unknown version of %s", insightName), http.StatusNotFound)
+		log.Printf("error, unknown versions of %s: from=%d to=%d", insightName, from, to)
+		return
+	}
+
+	changes, err := shared.DiffJSON(fromVersion.InsightJson, toVersion.InsightJson)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Failed trying to diff %s versions %d and %d: %s", insightName, from, to, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InsightDiff{
+		Name:    insightName,
+		From:    from,
+		To:      to,
+		Changes: changes,
+	})
+}
+
 func (s *Server) InsightsStream(w http.ResponseWriter, r *http.Request) {
 
 	// Set http headers required for SSE
@@ -227,13 +465,29 @@ func (s *Server) InsightsStream(w http.ResponseWriter, r *http.Request) {
 
 	// Send a comment line immediately so the connection is considered "active".
 	fmt.Fprintf(w, ": connected %s\n\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprint(w, retryHint())
+
+	// a reconnecting client gets the events it missed replayed
+	sub := s.Broker.Subscribe(r.Context(), uid, 16, parseLastEventID(r.Header.Get("Last-Event-ID")))
+	// replayed events may be delivered again once they reach this instance
+	var written int64
+	if sub.missed {
+		ua, err := s.Store.GetUser(uid)
+		if err == nil {
+			err = writeSnapshot(w, ua, sub.lastID)
+		}
+		if err != nil {
+			log.Printf("Error writing insight snapshot for user (%s): %s", uid, err)
+			return
+		}
+		// the snapshot is newer than anything buffered before it
+		written = sub.lastID
+	}
 	flusher.Flush()
 
 	heartbeat := time.NewTicker(15 * time.Second)
 	defer heartbeat.Stop()
 
-	sub := s.Broker.Subscribe(r.Context(), uid, 8)
-
 	for {
 		select {
 		case <-clientGone:
@@ -248,11 +502,21 @@ func (s *Server) InsightsStream(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			flusher.Flush()
-		case insightEvent, ok := <-sub.ch:
+		case insightEvent, ok := <-sub.Events():
 			if !ok {
+				if n := sub.Dropped(); n > 0 {
+					log.Printf("Stream of user (%s) fell behind, dropped %d events", uid, n)
+				}
+				return
+			}
+			if insightEvent.ID <= written {
+				continue
+			}
+			written = insightEvent.ID
+			if err := writeEvent(w, insightEvent); err != nil {
+				log.Printf("Error writing event: %s", err)
 				return
 			}
-			fmt.Fprintf(w, "event: %s\n", insightEvent.Name)
 			flusher.Flush()
 		}
 	}
@@ -296,6 +560,88 @@ func WithCORS(allowedOrigins []string) func(http.Handler) http.Handler {
 	}
 }
 
+func newInsightVersion(resp llm.Response, fingerprint string) *db.InsightVersion {
+	return &db.InsightVersion{
+		CreatedAt:   time.Now(),
+		PromptID:    resp.PromptID,
+		Model:       resp.Model,
+		Input:       resp.Input,
+		InsightJson: json.RawMessage(resp.Output),
+		Fingerprint: fingerprint,
+	}
+}
+
+// resolveInsightName returns the insight name for holistic or a dimension name or slug.
+func resolveInsightName(nameOrSlug string) (string, bool) {
+	if nameOrSlug == HOLISTIC {
+		return HOLISTIC, true
+	}
+	return questions.ResolveDimension(nameOrSlug)
+}
+
+// newInsightSummary describes insight; a missing insight is NOT_STARTED.
+func newInsightSummary(name string, insight db.Insight) InsightSummary {
+	summary := InsightSummary{
+		Name:   name,
+		Slug:   insightSlug(name),
+		Status: insight.Status,
+		Error:  insight.Error,
+		Stale:  insight.Stale,
+	}
+	if summary.Status == "" {
+		summary.Status = NOT_STARTED
+		return summary
+	}
+
+	createdAt := insight.CreatedAt
+	// insights stored before timestamps were recorded
+	if createdAt.IsZero() && len(insight.Versions) > 0 {
+		createdAt = insight.Versions[0].CreatedAt
+	}
+	if !createdAt.IsZero() {
+		summary.CreatedAt = &createdAt
+	}
+	if !insight.UpdatedAt.IsZero() {
+		summary.UpdatedAt = &insight.UpdatedAt
+	}
+	summary.Version = insight.LatestVersion()
+	summary.InsightJson = insight.InsightJson
+	return summary
+}
+
+func newInsightJob(job db.Job) InsightJob {
+	return InsightJob{
+		ID:        job.ID,
+		Insight:   job.Insight,
+		Status:    job.Status,
+		Attempts:  job.Attempts,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+}
+
+// storeErrorStatus maps store errors to HTTP status codes.
+func storeErrorStatus(err error) int {
+	if errors.Is(err, db.ErrUserNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %s", err)
+	}
+}
+
+// writeError answers with an ErrorResponse.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message})
+}
+
 func randomID(n int) (string, error) {
 	b := make([]byte, n)
 	if _, err := rand.Read(b); err != nil {
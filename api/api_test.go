@@ -0,0 +1,581 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"user-db/api"
+	"user-db/db"
+	"user-db/jobs"
+	"user-db/llm"
+	"user-db/questions"
+	"user-db/scoring"
+	"user-db/shared"
+)
+
+func newTestServer(t *testing.T) (*api.Server, db.UserStore) {
+	t.Helper()
+	store := db.NewMemoryStore()
+	if err := store.NewUser("user"); err != nil {
+		t.Fatalf("NewUser() failed: %v", err)
+	}
+	s := &api.Server{Broker: api.NewMemoryBroker(), Store: store, Insights: llm.NewFakeGenerator()}
+	s.Queue = jobs.NewQueue(store, s, testQueueOptions)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Queue.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s, store
+}
+
+var testQueueOptions = jobs.Options{
+	Workers:      2,
+	Lease:        time.Second,
+	MaxAttempts:  2,
+	Backoff:      time.Millisecond,
+	PollInterval: 10 * time.Millisecond,
+}
+
+func newRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.AddCookie(&http.Cookie{Name: api.COOKIENAME, Value: "user"})
+	return r
+}
+
+func TestSubmitResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantErrors int
+		wantStored int
+	}{
+		{
+			name:       "valid",
+			body:       `{"answers":[{"questionid":1,"kind":"SCALE","value":3},{"questionid":2,"kind":"SCALE","value":9}]}`,
+			wantStatus: http.StatusOK,
+			wantStored: 2,
+		},
+		{
+			name:       "one-invalid-kind",
+			body:       `{"answers":[{"questionid":1,"kind":"SCALE","value":3},{"questionid":2,"kind":"FOO","value":9}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: 1,
+		},
+		{
+			name:       "duplicate-question",
+			body:       `{"answers":[{"questionid":1,"kind":"SCALE","value":3},{"questionid":1,"kind":"SCALE","value":9}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: 1,
+		},
+		{
+			name:       "unknown-question",
+			body:       `{"answers":[{"questionid":99999,"kind":"SCALE","value":3}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: 1,
+		},
+		{
+			name:       "out-of-scale",
+			body:       `{"answers":[{"questionid":1,"kind":"SCALE","value":-40},{"questionid":2,"kind":"SCALE","value":11}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: 2,
+		},
+		{
+			name:       "dont-know-allowed",
+			body:       `{"answers":[{"questionid":14,"kind":"DONTKNOW","value":0}]}`,
+			wantStatus: http.StatusOK,
+			wantStored: 1,
+		},
+		{
+			name:       "dont-know-needs-answer",
+			body:       `{"answers":[{"questionid":1,"kind":"DONTKNOW","value":0}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErrors: 1,
+		},
+		{
+			name:       "empty",
+			body:       `{"answers":[]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, store := newTestServer(t)
+
+			w := httptest.NewRecorder()
+			s.SubmitResponses(w, newRequest(http.MethodPost, "/v1/responses", tt.body))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("SubmitResponses() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var result api.SubmitResult
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if result.Success != (tt.wantStatus == http.StatusOK) || len(result.Errors) != tt.wantErrors {
+				t.Errorf("SubmitResponses() = %+v", result)
+			}
+
+			ua, _ := store.GetUser("user")
+			if len(ua.Answers) != tt.wantStored {
+				t.Errorf("stored %d answers, want %d", len(ua.Answers), tt.wantStored)
+			}
+		})
+	}
+}
+
+// failingGenerator fails every generation.
+type failingGenerator struct{}
+
+func (failingGenerator) HolisticInsight(ctx context.Context, scores scoring.Result) (llm.Response, error) {
+	return llm.Response{}, errors.New("model unavailable")
+}
+
+func (failingGenerator) DimensionInsight(ctx context.Context, dimension scoring.DimensionScore, prompt shared.PromptConfig) (llm.Response, error) {
+	return llm.Response{}, errors.New("model unavailable")
+}
+
+// slowGenerator holds the first blocks generations until they are released.
+type slowGenerator struct {
+	*llm.FakeGenerator
+	mu      sync.Mutex
+	blocks  int
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowGenerator(blocks int) *slowGenerator {
+	return &slowGenerator{
+		FakeGenerator: llm.NewFakeGenerator(),
+		blocks:        blocks,
+		started:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+}
+
+func (g *slowGenerator) DimensionInsight(ctx context.Context, dimension scoring.DimensionScore, prompt shared.PromptConfig) (llm.Response, error) {
+	g.mu.Lock()
+	block := g.blocks > 0
+	g.blocks--
+	g.mu.Unlock()
+
+	if block {
+		g.started <- struct{}{}
+		<-g.release
+	}
+	return g.FakeGenerator.DimensionInsight(ctx, dimension, prompt)
+}
+
+// waitFor polls the store until done reports true for the user.
+func waitFor(t *testing.T, store db.UserStore, done func(ua db.UserAnswers) bool) db.UserAnswers {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ua, err := store.GetUser("user")
+		if err != nil {
+			t.Fatalf("GetUser() failed: %v", err)
+		}
+		if done(ua) {
+			return ua
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for insights: %+v", ua.Insights)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForInsight polls the store until the insight leaves GENERATING.
+func waitForInsight(t *testing.T, store db.UserStore, insightName string) db.UserAnswers {
+	t.Helper()
+	return waitFor(t, store, func(ua db.UserAnswers) bool {
+		insight, ok := ua.Insights[insightName]
+		return ok && insight.Status != db.GENERATING
+	})
+}
+
+func submitDimension(t *testing.T, s *api.Server, dimension string, value int) {
+	t.Helper()
+	var answers []string
+	for id, q := range questions.GetQuestions() {
+		if q.Dimension == dimension {
+			answers = append(answers, fmt.Sprintf(`{"questionid":%d,"kind":"SCALE","value":%d}`, id, value))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.SubmitResponses(w, newRequest(http.MethodPost, "/v1/responses", `{"answers":[`+strings.Join(answers, ",")+`]}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("SubmitResponses() status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestSubmitResponses_GeneratesInsight(t *testing.T) {
+	s, store := newTestServer(t)
+	submitDimension(t, s, "Spirituality", 6)
+
+	ua := waitForInsight(t, store, "Spirituality")
+	if !ua.HasInsight("Spirituality") {
+		t.Fatalf("insight = %+v, want DONE", ua.Insights["Spirituality"])
+	}
+	var insight struct{ Dimension string }
+	if err := json.Unmarshal(ua.GetInsight("Spirituality"), &insight); err != nil || insight.Dimension != "Spirituality" {
+		t.Errorf("insight = %s, %v", ua.GetInsight("Spirituality"), err)
+	}
+	if v, _ := ua.GetInsightVersion("Spirituality", 1); v.Model != llm.FAKE_MODEL {
+		t.Errorf("insight model = %q, want %q", v.Model, llm.FAKE_MODEL)
+	}
+}
+
+func TestSubmitResponses_InsightFails(t *testing.T) {
+	s, store := newTestServer(t)
+	s.Insights = failingGenerator{}
+	submitDimension(t, s, "Spirituality", 6)
+
+	ua := waitForInsight(t, store, "Spirituality")
+	insight := ua.Insights["Spirituality"]
+	if insight.Status != db.FAILED || insight.Error != "model unavailable" {
+		t.Errorf("insight = %+v, want FAILED", insight)
+	}
+	if !ua.NeedsInsight("Spirituality") {
+		t.Errorf("NeedsInsight() = false for failed insight")
+	}
+}
+
+func TestSubmitResponses_RefreshesStaleInsight(t *testing.T) {
+	s, store := newTestServer(t)
+	s.Debounce = 100 * time.Millisecond
+	submitDimension(t, s, "Spirituality", 6)
+	waitForInsight(t, store, "Spirituality")
+
+	// the same answers again leave the insight alone
+	submitDimension(t, s, "Spirituality", 6)
+	if ua, _ := store.GetUser("user"); ua.Insights["Spirituality"].Stale {
+		t.Errorf("insight stale after submitting the same answers")
+	}
+
+	submitDimension(t, s, "Spirituality", 2)
+	ua, _ := store.GetUser("user")
+	if !ua.Insights["Spirituality"].Stale {
+		t.Errorf("insight not stale after its answers changed: %+v", ua.Insights["Spirituality"])
+	}
+
+	ua = waitFor(t, store, func(ua db.UserAnswers) bool {
+		return len(ua.GetInsightVersions("Spirituality")) == 2 && ua.HasInsight("Spirituality")
+	})
+	insight := ua.Insights["Spirituality"]
+	if insight.Stale {
+		t.Errorf("regenerated insight still stale")
+	}
+	first, _ := ua.GetInsightVersion("Spirituality", 1)
+	second, _ := ua.GetInsightVersion("Spirituality", 2)
+	if first.Fingerprint == "" || first.Fingerprint == second.Fingerprint {
+		t.Errorf("fingerprints = %q, %q, want two different ones", first.Fingerprint, second.Fingerprint)
+	}
+}
+
+func TestSubmitResponses_AnswersChangeDuringGeneration(t *testing.T) {
+	s, store := newTestServer(t)
+	s.Debounce = 20 * time.Millisecond
+	g := newSlowGenerator(2)
+	s.Insights = g
+
+	// answers change during two generations in a row, more often than a
+	// job is attempted
+	submitDimension(t, s, "Spirituality", 6)
+	for _, value := range []int{2, 4} {
+		select {
+		case <-g.started:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("generation not started")
+		}
+		submitDimension(t, s, "Spirituality", value)
+		g.release <- struct{}{}
+	}
+
+	ua := waitForInsight(t, store, "Spirituality")
+	if !ua.HasInsight("Spirituality") {
+		t.Fatalf("insight = %+v, want DONE", ua.Insights["Spirituality"])
+	}
+	var ids []int
+	for id, q := range questions.GetQuestions() {
+		if q.Dimension == "Spirituality" {
+			ids = append(ids, id)
+		}
+	}
+	versions := ua.GetInsightVersions("Spirituality")
+	if len(versions) != 1 || versions[0].Fingerprint != ua.Fingerprint(ids) {
+		t.Errorf("versions = %+v, want one from the latest answers", versions)
+	}
+}
+
+func TestQueueHolistic(t *testing.T) {
+	s, store := newTestServer(t)
+	if _, err := store.UpsertAnswers("user", []db.AnswerUpdate{{QuestionID: 1, Kind: shared.SCALE, Value: 4}}); err != nil {
+		t.Fatalf("UpsertAnswers() failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.QueueHolistic(w, newRequest(http.MethodGet, "/v1/insights/holistic", ""))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("QueueHolistic() with GET status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	w = httptest.NewRecorder()
+	s.QueueHolistic(w, newRequest(http.MethodPost, "/v1/insights/holistic", ""))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("QueueHolistic() status = %d: %s", w.Code, w.Body)
+	}
+	var job api.InsightJob
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if job.ID == "" || job.Insight != api.HOLISTIC || w.Header().Get("Location") != api.JOBS_PATH+job.ID {
+		t.Errorf("QueueHolistic() = %+v, location %q", job, w.Header().Get("Location"))
+	}
+
+	getJob := func(uid string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, api.JOBS_PATH+job.ID, nil)
+		r.AddCookie(&http.Cookie{Name: api.COOKIENAME, Value: uid})
+		r.SetPathValue("id", job.ID)
+		w := httptest.NewRecorder()
+		s.GetInsightJob(w, r)
+		return w
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for job.Status != db.JOB_DONE {
+		if time.Now().After(deadline) {
+			t.Fatalf("job = %+v, want done", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		w := getJob("user")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GetInsightJob() status = %d: %s", w.Code, w.Body)
+		}
+		if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+	}
+	if ua, _ := store.GetUser("user"); !ua.HasInsight(api.HOLISTIC) {
+		t.Errorf("holistic insight not stored: %+v", ua.Insights)
+	}
+
+	if w := getJob("other"); w.Code != http.StatusNotFound {
+		t.Errorf("GetInsightJob() of other user status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestGetScores(t *testing.T) {
+	s, store := newTestServer(t)
+	if _, err := store.UpsertAnswers("user", []db.AnswerUpdate{
+		{QuestionID: 2, Kind: shared.SCALE, Value: 4},
+		{QuestionID: 3, Kind: shared.SCALE, Value: 7},
+	}); err != nil {
+		t.Fatalf("UpsertAnswers() failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.GetScores(w, newRequest(http.MethodGet, "/v1/scores", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetScores() status = %d: %s", w.Code, w.Body)
+	}
+
+	var result scoring.Result
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	physical, ok := result.Dimension("Physical Health")
+	if !ok {
+		t.Fatalf("GetScores() has no Physical Health: %+v", result)
+	}
+	if physical.Value != 5.5 || physical.Answered != 2 || len(physical.SubDimensions) == 0 {
+		t.Errorf("GetScores() Physical Health = %+v", physical)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/scores", nil)
+	r.AddCookie(&http.Cookie{Name: api.COOKIENAME, Value: "unknown"})
+	s.GetScores(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GetScores() for unknown user status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestGetProgress(t *testing.T) {
+	s, store := newTestServer(t)
+
+	// all general questions answered, Spirituality is the worst
+	var updates []db.AnswerUpdate
+	for _, id := range []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 51, 52} {
+		updates = append(updates, db.AnswerUpdate{QuestionID: id, Kind: shared.SCALE, Value: 5})
+	}
+	updates[12].Value = 1
+	if _, err := store.UpsertAnswers("user", updates); err != nil {
+		t.Fatalf("UpsertAnswers() failed: %v", err)
+	}
+	if err := store.UpsertInsight("user", "Spirituality", db.GENERATING, nil); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+	// the holistic insight is regenerated
+	if err := store.UpsertInsight("user", api.HOLISTIC, db.DONE, &db.InsightVersion{CreatedAt: time.Now(), InsightJson: []byte(`{"a":1}`)}); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+	if err := store.UpsertInsight("user", api.HOLISTIC, db.GENERATING, nil); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.GetProgress(w, newRequest(http.MethodGet, "/v1/progress", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetProgress() status = %d: %s", w.Code, w.Body)
+	}
+
+	var progress api.Progress
+	if err := json.NewDecoder(w.Body).Decode(&progress); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if progress.NextDimension != "Spirituality" {
+		t.Errorf("GetProgress() nextDimension = %q, want Spirituality", progress.NextDimension)
+	}
+	if progress.HolisticStatus != db.GENERATING || !progress.HolisticAvailable {
+		t.Errorf("GetProgress() holistic = %q, available %t, want GENERATING and available", progress.HolisticStatus, progress.HolisticAvailable)
+	}
+	for _, dim := range progress.Dimensions {
+		switch dim.Name {
+		case "Happiness & Life Satisfaction":
+			if !dim.Complete || dim.Answered != 1 {
+				t.Errorf("GetProgress() %s = %+v, want complete", dim.Name, dim)
+			}
+		case "Spirituality":
+			if dim.Complete || dim.Answered != 1 || dim.Total != 5 || len(dim.SubDimensions) != 1 {
+				t.Errorf("GetProgress() %s = %+v", dim.Name, dim)
+			}
+			if dim.InsightStatus != db.GENERATING || dim.InsightAvailable {
+				t.Errorf("GetProgress() %s insightStatus = %q, available %t, want GENERATING for the first time", dim.Name, dim.InsightStatus, dim.InsightAvailable)
+			}
+		}
+	}
+}
+
+func TestGetInsightsLLM(t *testing.T) {
+	s, store := newTestServer(t)
+	version := &db.InsightVersion{CreatedAt: time.Now(), InsightJson: []byte(`{"a":1}`)}
+	for _, name := range []string{"Habits", "Spirituality", "Social Relationships"} {
+		if err := store.UpsertInsight("user", name, db.DONE, version); err != nil {
+			t.Fatalf("UpsertInsight() failed: %v", err)
+		}
+	}
+	// regenerations keep the previous version visible
+	if err := store.UpsertInsight("user", "Spirituality", db.GENERATING, nil); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+	if err := store.FailInsight("user", "Social Relationships", "model unavailable"); err != nil {
+		t.Fatalf("FailInsight() failed: %v", err)
+	}
+	if err := store.UpsertInsight("user", api.HOLISTIC, db.GENERATING, nil); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.GetInsightsLLM(w, newRequest(http.MethodGet, "/v1/insights/llm", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetInsightsLLM() status = %d: %s", w.Code, w.Body)
+	}
+	var insights map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&insights); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	want := map[string]string{"Habits": `{"a":1}`, "Spirituality": `{"a":1}`, "Social Relationships": `{"a":1}`}
+	if !maps.Equal(insights, want) {
+		t.Errorf("GetInsightsLLM() = %v, want %v", insights, want)
+	}
+}
+
+func TestGetInsights(t *testing.T) {
+	s, store := newTestServer(t)
+	if err := store.UpsertInsight("user", "Habits", db.GENERATING, nil); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+	if err := store.UpsertInsight("user", "Spirituality", db.DONE, &db.InsightVersion{CreatedAt: time.Now(), InsightJson: []byte(`{"a":1}`)}); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+	if err := store.MarkInsightStale("user", "Spirituality"); err != nil {
+		t.Fatalf("MarkInsightStale() failed: %v", err)
+	}
+
+	list := func(target string) map[string]api.InsightSummary {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.GetInsights(w, newRequest(http.MethodGet, target, ""))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GetInsights(%s) status = %d: %s", target, w.Code, w.Body)
+		}
+		var result api.InsightList
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		insights := map[string]api.InsightSummary{}
+		for _, insight := range result.Insights {
+			insights[insight.Slug] = insight
+		}
+		return insights
+	}
+
+	want := 1
+	for _, dimension := range questions.GetCatalogue() {
+		if dimension.Insights {
+			want++
+		}
+	}
+	insights := list("/v1/insights")
+	if len(insights) != want {
+		t.Errorf("GetInsights() listed %d insights, want %d: every dimension with insights and holistic", len(insights), want)
+	}
+	if _, ok := insights["character-virtue"]; ok {
+		t.Errorf("GetInsights() listed Character & Virtue, which has no insights")
+	}
+	if holistic := insights[api.HOLISTIC]; holistic.Status != api.NOT_STARTED || holistic.CreatedAt != nil {
+		t.Errorf("holistic insight = %+v, want NOT_STARTED", holistic)
+	}
+	if habits := insights["habits"]; habits.Status != db.GENERATING || habits.CreatedAt == nil || habits.UpdatedAt == nil || habits.Version != 0 {
+		t.Errorf("Habits insight = %+v, want GENERATING with timestamps", habits)
+	}
+	if spirituality := insights["spirituality"]; spirituality.Status != db.DONE || !spirituality.Stale || spirituality.Version != 1 || string(spirituality.InsightJson) != `{"a":1}` {
+		t.Errorf("Spirituality insight = %+v, want stale version 1", spirituality)
+	}
+
+	if insights := list("/v1/insights?dimension=spirituality"); len(insights) != 1 || insights["spirituality"].Name != "Spirituality" {
+		t.Errorf("GetInsights() filtered by dimension = %+v", insights)
+	}
+
+	errorBody := func(r *http.Request, wantStatus int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.GetInsights(w, r)
+		var body api.ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); w.Code != wantStatus || err != nil || body.Error == "" {
+			t.Errorf("GetInsights(%s) = %d %+v, want %d with error body", r.URL, w.Code, body, wantStatus)
+		}
+	}
+	errorBody(newRequest(http.MethodGet, "/v1/insights?dimension=unknown", ""), http.StatusBadRequest)
+	errorBody(newRequest(http.MethodGet, "/v1/insights?dimension=character-virtue", ""), http.StatusBadRequest)
+	unknown := httptest.NewRequest(http.MethodGet, "/v1/insights", nil)
+	unknown.AddCookie(&http.Cookie{Name: api.COOKIENAME, Value: "unknown"})
+	errorBody(unknown, http.StatusNotFound)
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"user-db/db"
+)
+
+// Broker fans out insight events to the streams of a user.
+type Broker interface {
+	Publish(ev InsightEvent)
+	// Subscribe registers a subscriber for the events of user. Events
+	// published after lastEventID are replayed first; 0 subscribes without
+	// replay.
+	Subscribe(ctx context.Context, user string, buf int, lastEventID int64) *Subscriber
+	// Close ends all subscriptions, letting subscribers drain what is
+	// buffered for them.
+	Close()
+}
+
+// NewBroker returns the broker named by kind: "memory" or "" for a
+// MemoryBroker, "store" for an OutboxBroker on store.
+func NewBroker(ctx context.Context, kind string, store db.EventStore) (Broker, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryBroker(), nil
+	case "store":
+		return NewOutboxBroker(ctx, store, time.Second)
+	default:
+		return nil, fmt.Errorf("unknown broker %q", kind)
+	}
+}
+
+// Subscriber receives the events of one user. Its channel is closed when the
+// subscription ends: when its context is done, when it falls behind, or when
+// the broker is closed. Events already buffered can still be received.
+type Subscriber struct {
+	ch   chan InsightEvent
+	user string
+	// lastID is the ID of the last event published before the subscription,
+	// or of the last replayed one
+	lastID int64
+	// missed is set if events after the requested Last-Event-ID are no
+	// longer available, so the subscriber has to catch up otherwise
+	missed bool
+	// dropped counts the events not delivered because the buffer was full
+	dropped atomic.Int64
+}
+
+// Events returns the channel the events are delivered on.
+func (s *Subscriber) Events() <-chan InsightEvent { return s.ch }
+
+// Dropped returns the number of events the subscriber did not receive
+// because it fell behind.
+func (s *Subscriber) Dropped() int64 { return s.dropped.Load() }
+
+// HISTORY_SIZE is the number of events kept per user for replay.
+const HISTORY_SIZE = 32
+
+// HISTORY_TTL is how long the history of a user without subscribers is kept
+// after its last event.
+const HISTORY_TTL = 10 * time.Minute
+
+// history holds the latest events of a user, oldest first.
+type history struct {
+	events []InsightEvent
+	// evicted is the ID of the newest event no longer in events
+	evicted int64
+	// updated is when the last event was added
+	updated time.Time
+}
+
+// MemoryBroker delivers events within this instance.
+//
+// The broker owns the channel of every subscriber: it is only sent on and
+// closed by the broker while holding mu, and sends never block, so a send
+// cannot race the close and a slow subscriber cannot stall publishers.
+type MemoryBroker struct {
+	// HistoryTTL is how long the history of a user without subscribers is
+	// kept after its last event, HISTORY_TTL by default
+	HistoryTTL time.Duration
+
+	mu        sync.Mutex
+	subs      map[string]map[*Subscriber]struct{}
+	histories map[string]*history
+	// evicted is the ID of the newest event of any history dropped after
+	// HistoryTTL
+	evicted int64
+	swept   time.Time
+	// noHistory is set if replay is served elsewhere, e.g. by the outbox
+	noHistory bool
+	// IDs of events published by this broker start after startID
+	startID int64
+	lastID  int64
+	closed  bool
+	// dropped counts the events not delivered to any subscriber
+	dropped int64
+}
+
+// NewMemoryBroker returns a broker that keeps the last events of every user
+// for replay.
+func NewMemoryBroker() *MemoryBroker {
+	// IDs start at the current time, so that they keep increasing across
+	// restarts and IDs of an earlier run are recognised as too old
+	return newMemoryBroker(time.Now().UnixMilli())
+}
+
+// newMemoryBroker returns a broker whose IDs start after startID.
+func newMemoryBroker(startID int64) *MemoryBroker {
+	return &MemoryBroker{
+		HistoryTTL: HISTORY_TTL,
+		subs:       map[string]map[*Subscriber]struct{}{},
+		histories:  map[string]*history{},
+		swept:      time.Now(),
+		startID:    startID,
+		lastID:     startID,
+	}
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, user string, buf int, lastEventID int64) *Subscriber {
+	return b.subscribe(ctx, user, buf, lastEventID, nil, false)
+}
+
+// subscribe sends replay to the subscriber before any event after
+// lastEventID, unless events were missed. missed is passed on to the
+// subscriber.
+func (b *MemoryBroker) subscribe(ctx context.Context, user string, buf int, lastEventID int64, replay []InsightEvent, missed bool) *Subscriber {
+	sub := &Subscriber{ch: make(chan InsightEvent, buf), user: user, missed: missed}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(sub.ch)
+		return sub
+	}
+	sub.lastID = max(b.lastID, lastEventID)
+	h := b.histories[user]
+	if lastEventID > 0 {
+		// the events of a dropped history are gone, and IDs are not kept per
+		// user once it is dropped
+		sub.missed = sub.missed || lastEventID < b.startID || lastEventID < b.evicted || (h != nil && lastEventID < h.evicted)
+		// replay what the subscriber missed since its last event
+		if h != nil {
+			for _, ev := range h.events {
+				if ev.ID > lastEventID {
+					replay = append(replay, ev)
+				}
+			}
+		}
+	}
+	// a replay that does not fit the buffer is skipped as a whole, the
+	// subscriber catches up otherwise
+	if len(replay) > buf {
+		sub.missed = true
+	}
+	if !sub.missed {
+		for _, ev := range replay {
+			sub.ch <- ev
+		}
+	}
+	if b.subs[user] == nil {
+		b.subs[user] = map[*Subscriber]struct{}{}
+	}
+	b.subs[user][sub] = struct{}{}
+	b.mu.Unlock()
+
+	// no goroutine waits for the context; the function runs once it is done
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(sub)
+	})
+	return sub
+}
+
+// remove drops a subscriber and closes its channel, at most once. b.mu must
+// be held.
+func (b *MemoryBroker) remove(sub *Subscriber) {
+	m := b.subs[sub.user]
+	if _, ok := m[sub]; !ok {
+		return
+	}
+	delete(m, sub)
+	if len(m) == 0 {
+		delete(b.subs, sub.user)
+	}
+	close(sub.ch)
+}
+
+// Publish assigns the event the next ID and delivers it. It never blocks.
+func (b *MemoryBroker) Publish(ev InsightEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ev.ID = max(b.lastID+1, time.Now().UnixMilli())
+	b.fanOut(ev)
+}
+
+// deliver fans out an event that already has an ID.
+func (b *MemoryBroker) deliver(ev InsightEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fanOut(ev)
+}
+
+// fanOut keeps ev for replay and sends it to the subscribers of its user.
+// b.mu must be held.
+func (b *MemoryBroker) fanOut(ev InsightEvent) {
+	if b.closed {
+		return
+	}
+	b.lastID = max(b.lastID, ev.ID)
+	b.keep(ev)
+
+	for sub := range b.subs[ev.UserID] {
+		select {
+		case sub.ch <- ev:
+		default:
+			// a slow subscriber is disconnected and replays the missed
+			// events when it reconnects
+			sub.dropped.Add(1)
+			b.dropped++
+			b.remove(sub)
+		}
+	}
+}
+
+// keep adds ev to the history of its user. b.mu must be held.
+func (b *MemoryBroker) keep(ev InsightEvent) {
+	if b.noHistory {
+		return
+	}
+	now := time.Now()
+	if now.Sub(b.swept) >= b.HistoryTTL {
+		b.sweep(now)
+	}
+	h := b.histories[ev.UserID]
+	if h == nil {
+		h = &history{}
+		b.histories[ev.UserID] = h
+	}
+	h.updated = now
+	h.events = append(h.events, ev)
+	if len(h.events) > HISTORY_SIZE {
+		h.evicted = h.events[0].ID
+		h.events = h.events[1:]
+	}
+}
+
+// sweep drops the histories of users without subscribers whose last event
+// is older than HistoryTTL, so that the histories of users who left do not
+// pile up. b.mu must be held.
+func (b *MemoryBroker) sweep(now time.Time) {
+	b.swept = now
+	for user, h := range b.histories {
+		if len(b.subs[user]) > 0 || now.Sub(h.updated) < b.HistoryTTL {
+			continue
+		}
+		b.evicted = max(b.evicted, h.events[len(h.events)-1].ID)
+		delete(b.histories, user)
+	}
+}
+
+// Dropped returns the number of events not delivered to a subscriber
+// because it fell behind.
+func (b *MemoryBroker) Dropped() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// Close ends every subscription. Subscribers still receive the events
+// buffered for them; later events and subscriptions are discarded.
+func (b *MemoryBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, m := range b.subs {
+		for sub := range m {
+			b.remove(sub)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"user-db/api"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	b := api.NewMemoryBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := b.Subscribe(ctx, "user", 2, 0)
+	b.Publish(api.InsightEvent{Name: "Habits", UserID: "other", Kind: api.EVENT_DONE})
+	for range 3 {
+		b.Publish(api.InsightEvent{Name: "Habits", UserID: "user", Kind: api.EVENT_DONE})
+	}
+
+	// the third event does not fit, the subscriber is disconnected
+	var received []api.InsightEvent
+	for ev := range sub.Events() {
+		received = append(received, ev)
+	}
+	if len(received) != 2 || sub.Dropped() != 1 || b.Dropped() != 1 {
+		t.Errorf("received %d events, dropped %d (broker %d), want 2 and 1", len(received), sub.Dropped(), b.Dropped())
+	}
+
+	// reconnecting replays the dropped event
+	again := b.Subscribe(ctx, "user", 2, received[1].ID)
+	if ev := <-again.Events(); ev.ID <= received[1].ID {
+		t.Errorf("replayed event %d, want after %d", ev.ID, received[1].ID)
+	}
+
+	// closing lets subscribers drain their buffer
+	last := b.Subscribe(ctx, "user", 2, 0)
+	b.Publish(api.InsightEvent{Name: "Habits", UserID: "user", Kind: api.EVENT_FAILED})
+	b.Close()
+	if ev, ok := <-last.Events(); !ok || ev.Kind != api.EVENT_FAILED {
+		t.Errorf("buffered event after Close() = %+v, %v", ev, ok)
+	}
+	if _, ok := <-last.Events(); ok {
+		t.Error("subscription still open after Close()")
+	}
+	if _, ok := <-b.Subscribe(ctx, "user", 2, 0).Events(); ok {
+		t.Error("Subscribe() after Close() returned an open subscription")
+	}
+	b.Publish(api.InsightEvent{Name: "Habits", UserID: "user", Kind: api.EVENT_DONE})
+}
+
+func TestMemoryBroker_HistoryTTL(t *testing.T) {
+	b := api.NewMemoryBroker()
+	b.HistoryTTL = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// counts the events of user replayed after lastEventID
+	replayed := func(user string, lastEventID int64) int {
+		subCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		sub := b.Subscribe(subCtx, user, 4, lastEventID)
+		for n := 0; ; n++ {
+			select {
+			case <-sub.Events():
+			default:
+				return n
+			}
+		}
+	}
+	// publishes an event for user and returns its ID
+	publish := func(sub *api.Subscriber, user string) int64 {
+		b.Publish(api.InsightEvent{Name: "Habits", UserID: user, Kind: api.EVENT_DONE})
+		return (<-sub.Events()).ID
+	}
+
+	goneCtx, leave := context.WithCancel(ctx)
+	gone := publish(b.Subscribe(goneCtx, "gone", 4, 0), "gone")
+	leave()
+	listening := publish(b.Subscribe(ctx, "listening", 4, 0), "listening")
+	if n := replayed("gone", gone-1); n != 1 {
+		t.Fatalf("replayed %d events, want 1", n)
+	}
+
+	// the next event drops the expired history of the user without
+	// subscribers
+	time.Sleep(50 * time.Millisecond)
+	b.Publish(api.InsightEvent{Name: "Habits", UserID: "other", Kind: api.EVENT_DONE})
+	if n := replayed("gone", gone-1); n != 0 {
+		t.Errorf("replayed %d events of expired history, want 0", n)
+	}
+	if n := replayed("listening", listening-1); n != 1 {
+		t.Errorf("replayed %d events of user with subscriber, want 1", n)
+	}
+}
+
+// TestMemoryBroker_Storm is meant to be run with -race.
+func TestMemoryBroker_Storm(t *testing.T) {
+	b := api.NewMemoryBroker()
+	var wg sync.WaitGroup
+
+	for i := range 8 {
+		user := fmt.Sprintf("user%d", i%2)
+		wg.Go(func() {
+			for range 200 {
+				b.Publish(api.InsightEvent{Name: "Habits", UserID: user, Kind: api.EVENT_DONE})
+			}
+		})
+		wg.Go(func() {
+			for range 50 {
+				ctx, cancel := context.WithCancel(context.Background())
+				sub := b.Subscribe(ctx, user, 4, 0)
+				select {
+				case <-sub.Events():
+				default:
+				}
+				cancel()
+				// the channel is closed once the subscription is removed
+				for range sub.Events() {
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	// closing while subscribing and publishing
+	for range 4 {
+		wg.Go(func() {
+			sub := b.Subscribe(context.Background(), "user0", 4, 0)
+			b.Publish(api.InsightEvent{Name: "Habits", UserID: "user0", Kind: api.EVENT_DONE})
+			for range sub.Events() {
+			}
+		})
+	}
+	wg.Go(b.Close)
+	wg.Wait()
+	b.Close()
+}
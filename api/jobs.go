@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"user-db/db"
+	"user-db/jobs"
+	"user-db/llm"
+	"user-db/questions"
+	"user-db/scoring"
+	"user-db/shared"
+)
+
+var errAnswersChanged = errors.New("answers changed during generation")
+
+// queueInsights queues the insights that are due after answers changed:
+// the insights of newly complete dimensions right away, and the insights
+// whose answers changed after s.Debounce. Changed insights are marked stale
+// until they are regenerated.
+func (s *Server) queueInsights(userID string, ua db.UserAnswers) {
+	for _, dimensionName := range questions.GetCompleteDimensions(ua) {
+		if ua.NeedsInsight(dimensionName) {
+			s.enqueueInsight(userID, dimensionName, 0)
+			continue
+		}
+		s.refreshIfStale(userID, ua, dimensionName)
+	}
+	s.refreshIfStale(userID, ua, HOLISTIC)
+}
+
+// refreshIfStale marks a generated insight as stale and queues it again if
+// its answers changed. Insights generated before fingerprints are left alone.
+func (s *Server) refreshIfStale(userID string, ua db.UserAnswers, insightName string) {
+	if !ua.HasInsight(insightName) {
+		return
+	}
+	fingerprint := ua.InsightFingerprint(insightName)
+	if fingerprint == "" || fingerprint == insightFingerprint(ua, insightName) {
+		return
+	}
+
+	if !ua.Insights[insightName].Stale {
+		if err := s.Store.MarkInsightStale(userID, insightName); err != nil {
+			log.Printf("Failed trying to mark insight %s as stale: %s", insightName, err)
+			return
+		}
+		s.publishProgress(userID, insightName, EVENT_STALE, "")
+	}
+	s.enqueueInsight(userID, insightName, s.Debounce)
+}
+
+// enqueueInsight queues an insight and tells the client, unless its job is
+// already running.
+func (s *Server) enqueueInsight(userID, insightName string, delay time.Duration) (db.Job, error) {
+	job, err := s.Queue.Enqueue(userID, insightName, delay)
+	if err != nil {
+		log.Printf("Failed trying to enqueue insight %s for user (%s): %s", insightName, userID, err)
+		return job, err
+	}
+	if job.Status == db.JOB_QUEUED {
+		s.publishProgress(userID, insightName, EVENT_QUEUED, "")
+	}
+	return job, nil
+}
+
+// insightFingerprint fingerprints the answers an insight is generated from:
+// the questions of its dimension, or all questions for the holistic insight.
+func insightFingerprint(ua db.UserAnswers, insightName string) string {
+	var ids []int
+	for id, q := range questions.GetQuestions() {
+		if insightName == HOLISTIC || q.Dimension == insightName {
+			ids = append(ids, id)
+		}
+	}
+	return ua.Fingerprint(ids)
+}
+
+// RunJob generates the insight of a job from the current answers of the user.
+// The insight stays GENERATING while the job is retried. A generation
+// outdated by answers submitted meanwhile is discarded and queued again after
+// s.Debounce. Only transient generation failures are retried.
+func (s *Server) RunJob(ctx context.Context, job db.Job) error {
+	ua, err := s.Store.GetUser(job.UserID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		log.Printf("Dropping insight %s for deleted user (%s)", job.Insight, job.UserID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.Store.StartInsight(job.UserID, job.Insight, job.Lease()); err != nil {
+		return err
+	}
+	s.publishProgress(job.UserID, job.Insight, EVENT_GENERATING, "")
+
+	fingerprint := insightFingerprint(ua, job.Insight)
+	scores := scoring.Compute(ua, shared.MapToSlice(questions.GetQuestions()), questions.GetDimensions())
+	resp, err := s.generateInsight(ctx, job.Insight, scores)
+	// the lease or the queue ending are not failures of the model
+	if err != nil && ctx.Err() == nil && !llm.IsTransient(err) {
+		return &jobs.Permanent{Err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	// answers submitted during the generation make it outdated
+	ua, err = s.Store.GetUser(job.UserID)
+	if err != nil {
+		return err
+	}
+	if insightFingerprint(ua, job.Insight) != fingerprint {
+		return &jobs.Superseded{Delay: s.Debounce, Cause: errAnswersChanged}
+	}
+
+	// after the lease has expired the job may run on another worker, whose
+	// attempt then stores the insight instead
+	if err := s.Store.FinishInsight(job.UserID, job.Insight, job.Lease(), *newInsightVersion(resp, fingerprint)); err != nil {
+		return err
+	}
+
+	ev := newInsightEvent(job.UserID, job.Insight, EVENT_DONE)
+	ev.Data = json.RawMessage(resp.Output)
+	s.Broker.Publish(ev)
+	return nil
+}
+
+// JobFailed marks the insight of a job that ran out of attempts as FAILED.
+func (s *Server) JobFailed(job db.Job, err error) {
+	s.failInsight(job.UserID, job.Insight, err)
+}
+
+// JobRequeued tells the client that a superseded insight is queued again.
+func (s *Server) JobRequeued(job db.Job) {
+	s.publishProgress(job.UserID, job.Insight, EVENT_QUEUED, "")
+}
+
+// generateInsight generates the holistic or a dimension insight and checks it
+// against its schema.
+func (s *Server) generateInsight(ctx context.Context, insightName string, scores scoring.Result) (llm.Response, error) {
+	if insightName == HOLISTIC {
+		resp, err := s.Insights.HolisticInsight(ctx, scores)
+		if err == nil {
+			_, err = llm.ParseHolistic([]byte(resp.Output))
+		}
+		return resp, err
+	}
+
+	dimScore, ok := scores.Dimension(insightName)
+	if !ok {
+		return llm.Response{}, fmt.Errorf("unknown dimension %s", insightName)
+	}
+	config, _ := questions.GetDimensionConfig(insightName)
+	resp, err := s.Insights.DimensionInsight(ctx, dimScore, config.Prompt)
+	if err == nil {
+		_, err = llm.ParseDimension([]byte(resp.Output))
+	}
+	return resp, err
+}
+
+// failInsight stores a failed generation and tells the client about it.
+func (s *Server) failInsight(userID, insightName string, cause error) {
+	if err := s.Store.FailInsight(userID, insightName, cause.Error()); err != nil {
+		log.Printf("Failed trying to mark insight %s as failed: %s", insightName, err)
+	}
+	s.publishProgress(userID, insightName, EVENT_FAILED, cause.Error())
+}
+
+// publishProgress tells the client that an insight reached kind. reason is
+// the error of failed events.
+func (s *Server) publishProgress(userID, insightName string, kind InsightEventKind, reason string) {
+	ev := newInsightEvent(userID, insightName, kind)
+	ev.Data = InsightProgress{Kind: kind, Name: insightName, Slug: ev.Slug, Error: reason, At: ev.At}
+	s.Broker.Publish(ev)
+}
+
+func newInsightEvent(userID, insightName string, kind InsightEventKind) InsightEvent {
+	return InsightEvent{
+		Kind:   kind,
+		Name:   insightName,
+		Slug:   insightSlug(insightName),
+		UserID: userID,
+		At:     time.Now().UTC(),
+	}
+}
+
+// insightSlug is the slug of the dimension of an insight, or HOLISTIC.
+func insightSlug(insightName string) string {
+	if insightName == HOLISTIC {
+		return HOLISTIC
+	}
+	return shared.Slugify(insightName)
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+	"user-db/db"
+)
+
+// REPLAY_LIMIT bounds the events replayed to a reconnecting stream. A
+// stream that missed more gets the stored insights instead.
+const REPLAY_LIMIT = 100
+
+// OUTBOX_GRACE is how long a missing event ID is waited for. IDs are handed
+// out before the event is written, so another instance may still be writing
+// it.
+const OUTBOX_GRACE = 5 * time.Second
+
+// OutboxBroker delivers events across instances. Published events are
+// appended to the EventStore, and every instance polls the store and fans
+// new events out to the streams connected to it, in the order of their IDs.
+type OutboxBroker struct {
+	store db.EventStore
+	local *MemoryBroker
+	// mu is held while delivering polled events and while a subscriber
+	// reads its replay and subscribes, so that no event is delivered in
+	// between and lost to the subscriber
+	mu     sync.Mutex
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewOutboxBroker starts polling store every pollInterval until ctx is done
+// or the broker is closed.
+// Only events published after the start are delivered.
+func NewOutboxBroker(ctx context.Context, store db.EventStore, pollInterval time.Duration) (*OutboxBroker, error) {
+	_, last, err := store.EventBounds()
+	if err != nil {
+		return nil, err
+	}
+
+	// streams are replayed from the store, not from a local history
+	local := newMemoryBroker(0)
+	local.noHistory = true
+
+	ctx, cancel := context.WithCancel(ctx)
+	b := &OutboxBroker{
+		store:  store,
+		local:  local,
+		wake:   make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go b.run(ctx, last, pollInterval)
+	return b, nil
+}
+
+// Close stops polling and ends every subscription.
+func (b *OutboxBroker) Close() {
+	b.cancel()
+	<-b.done
+	b.local.Close()
+}
+
+func (b *OutboxBroker) Publish(ev InsightEvent) {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		log.Printf("Error encoding event %s for user (%s): %s", ev.Name, ev.UserID, err)
+		return
+	}
+	_, err = b.store.AppendEvent(db.Event{
+		UserID: ev.UserID,
+		Name:   ev.Name,
+		Kind:   string(ev.Kind),
+		Data:   data,
+	})
+	if err != nil {
+		log.Printf("Error storing event %s for user (%s): %s", ev.Name, ev.UserID, err)
+		return
+	}
+
+	// deliver to the streams of this instance without waiting for the poll
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (b *OutboxBroker) Subscribe(ctx context.Context, user string, buf int, lastEventID int64) *Subscriber {
+	if lastEventID == 0 {
+		return b.local.subscribe(ctx, user, buf, 0, nil, false)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	first, _, err := b.store.EventBounds()
+	var events []db.Event
+	if err == nil {
+		events, err = b.store.EventsAfter(user, lastEventID, REPLAY_LIMIT)
+	}
+	if err != nil {
+		log.Printf("Error reading events of user (%s): %s", user, err)
+	}
+	// events are gone if they expired or could not be read
+	missed := err != nil || len(events) == REPLAY_LIMIT || first == 0 || lastEventID < first-1
+
+	after := lastEventID
+	replay := make([]InsightEvent, len(events))
+	for i, ev := range events {
+		replay[i] = toInsightEvent(ev)
+		after = ev.ID
+	}
+	return b.local.subscribe(ctx, user, buf, after, replay, missed)
+}
+
+func (b *OutboxBroker) run(ctx context.Context, cursor int64, pollInterval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		cursor = b.poll(cursor)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.wake:
+		}
+	}
+}
+
+// poll delivers the events after cursor and returns the new cursor.
+func (b *OutboxBroker) poll(cursor int64) int64 {
+	for {
+		events, err := b.store.EventsAfter("", cursor, REPLAY_LIMIT)
+		if err != nil {
+			log.Printf("Error polling events: %s", err)
+			return cursor
+		}
+		for _, ev := range events {
+			if ev.ID != cursor+1 && time.Since(ev.CreatedAt) < OUTBOX_GRACE {
+				return cursor
+			}
+			b.mu.Lock()
+			b.local.deliver(toInsightEvent(ev))
+			b.mu.Unlock()
+			cursor = ev.ID
+		}
+		if len(events) < REPLAY_LIMIT {
+			return cursor
+		}
+	}
+}
+
+func toInsightEvent(ev db.Event) InsightEvent {
+	return InsightEvent{
+		ID:     ev.ID,
+		Kind:   InsightEventKind(ev.Kind),
+		Name:   ev.Name,
+		Slug:   insightSlug(ev.Name),
+		UserID: ev.UserID,
+		At:     ev.CreatedAt,
+		Data:   ev.Data,
+	}
+}
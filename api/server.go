@@ -1,80 +1,46 @@
 package api
 
 import (
-	"context"
+	"time"
+	"user-db/db"
+	"user-db/jobs"
+	"user-db/llm"
 )
 
 type Server struct {
-	Broker *Broker
-	// add DB, logger, etc.
+	Broker   Broker
+	Store    db.UserStore
+	Insights llm.InsightGenerator
+	// Queue runs the insight jobs, with the Server as its handler
+	Queue *jobs.Queue
+	// Debounce delays the regeneration of stale insights, so that a user
+	// changing several answers triggers a single generation
+	Debounce time.Duration
+	// add logger, etc.
 }
 
-type InsightEvent struct {
-	Name   string
-	UserID string      // user id this is for
-	Data   interface{} // JSON-serializable payload
-}
-
-type Subscriber struct {
-	ch   chan InsightEvent
-	done <-chan struct{}
-}
-
-type cmd struct {
-	kind string
-	user string
-	sub  *Subscriber
-	ev   InsightEvent
-}
-
-// TODO use mutex to prevent race conditions / panics
-type Broker struct {
-	cmds chan cmd
-}
-
-// NewBroker starts a single goroutine that owns the subscriber maps.
-func NewBroker(buffer int) *Broker {
-	b := &Broker{cmds: make(chan cmd, buffer)}
-	subs := map[string]map[*Subscriber]struct{}{}
+// InsightEventKind is the lifecycle step of an insight an event reports.
+type InsightEventKind string
 
-	go func() {
-		for c := range b.cmds {
-			switch c.kind {
-			case "sub":
-				if subs[c.user] == nil {
-					subs[c.user] = map[*Subscriber]struct{}{}
-				}
-				subs[c.user][c.sub] = struct{}{}
-			case "unsub":
-				if m := subs[c.user]; m != nil {
-					delete(m, c.sub)
-					if len(m) == 0 {
-						delete(subs, c.user)
-					}
-					close(c.sub.ch) // ok: only broker closes
-				}
-			case "pub":
-				for s := range subs[c.ev.UserID] {
-					select {
-					case s.ch <- c.ev:
-					default: // drop if slow
-					}
-				}
-			}
-		}
-	}()
-
-	return b
-}
+const (
+	EVENT_QUEUED     InsightEventKind = "queued"
+	EVENT_GENERATING InsightEventKind = "generating"
+	EVENT_DONE       InsightEventKind = "done"
+	EVENT_FAILED     InsightEventKind = "failed"
+	EVENT_STALE      InsightEventKind = "stale"
+)
 
-func (b *Broker) Subscribe(ctx context.Context, user string, buf int) *Subscriber {
-	sub := &Subscriber{ch: make(chan InsightEvent, buf), done: ctx.Done()}
-	b.cmds <- cmd{kind: "sub", user: user, sub: sub}
-	go func() {
-		<-ctx.Done()
-		b.cmds <- cmd{kind: "unsub", user: user, sub: sub}
-	}()
-	return sub
+type InsightEvent struct {
+	// ID is assigned by the Broker and increases with every event
+	ID   int64
+	Kind InsightEventKind
+	Name string
+	// Slug identifies the dimension of the insight, "holistic" for the
+	// holistic insight
+	Slug   string
+	UserID string    // user id this is for
+	At     time.Time // when the insight reached Kind
+	// Data is the insight JSON for done events and an InsightProgress
+	// otherwise
+	Data interface{}
 }
-
-func (b *Broker) Publish(ev InsightEvent) { b.cmds <- cmd{kind: "pub", ev: ev} }
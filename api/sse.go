@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"user-db/db"
+)
+
+// RETRY_MS is the reconnection delay suggested to stream clients.
+const RETRY_MS = 3000
+
+// writeEvent writes an insight event as a server-sent event frame with its
+// ID, so that a reconnecting client sends it as Last-Event-ID. Events are
+// named after their kind; done events are also sent the old way, see
+// writeDone.
+func writeEvent(w io.Writer, ev InsightEvent) error {
+	if ev.Kind != EVENT_DONE {
+		return writeFrame(w, ev.ID, string(ev.Kind), ev.Data)
+	}
+	insight, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+	return writeDone(w, ev.ID, ev.Name, ev.At, insight)
+}
+
+// writeDone writes a finished insight twice: as a frame named after the
+// insight with the bare insight JSON, which clients written before the done
+// event listen for, and as a done frame with an InsightDone.
+func writeDone(w io.Writer, id int64, name string, at time.Time, insight json.RawMessage) error {
+	if err := writeFrame(w, id, name, insight); err != nil {
+		return err
+	}
+	done := InsightDone{Kind: EVENT_DONE, Name: name, Slug: insightSlug(name), At: at, Insight: insight}
+	return writeFrame(w, id, string(EVENT_DONE), done)
+}
+
+// writeFrame writes one frame. data is encoded as JSON on a single line.
+func writeFrame(w io.Writer, id int64, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, payload); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("id: " + strconv.FormatInt(id, 10) + "\n")
+	sb.WriteString("event: " + event + "\n")
+	sb.WriteString("data: " + compact.String() + "\n\n")
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// writeSnapshot sends the current state of all insights of a user, for
+// clients whose missed events are no longer buffered: the latest version,
+// followed by the state of a generation or staleness.
+func writeSnapshot(w io.Writer, ua db.UserAnswers, id int64) error {
+	for _, name := range slices.Sorted(maps.Keys(ua.Insights)) {
+		insight := ua.Insights[name]
+		if len(insight.InsightJson) > 0 {
+			at := insight.UpdatedAt
+			if n := len(insight.Versions); n > 0 {
+				at = insight.Versions[n-1].CreatedAt
+			}
+			if err := writeDone(w, id, name, at, insight.InsightJson); err != nil {
+				return err
+			}
+		}
+
+		var kinds []InsightEventKind
+		switch insight.Status {
+		case db.GENERATING:
+			kinds = append(kinds, EVENT_GENERATING)
+		case db.FAILED:
+			kinds = append(kinds, EVENT_FAILED)
+		}
+		if insight.Stale {
+			kinds = append(kinds, EVENT_STALE)
+		}
+		for _, kind := range kinds {
+			progress := InsightProgress{Kind: kind, Name: name, Slug: insightSlug(name), At: insight.UpdatedAt}
+			if kind == EVENT_FAILED {
+				progress.Error = insight.Error
+			}
+			if err := writeFrame(w, id, string(kind), progress); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseLastEventID returns the Last-Event-ID sent by a reconnecting client,
+// 0 if there is none or it is invalid.
+func parseLastEventID(value string) int64 {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+func retryHint() string {
+	return fmt.Sprintf("retry: %d\n\n", RETRY_MS)
+}
@@ -0,0 +1,279 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"user-db/api"
+	"user-db/db"
+)
+
+type frame struct {
+	id    string
+	event string
+	data  string
+	retry string
+}
+
+// openStream connects to the insights stream and returns a reader positioned
+// after the subscription.
+func openStream(t *testing.T, url, lastEventID string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.AddCookie(&http.Cookie{Name: api.COOKIENAME, Value: "user"})
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not open stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("stream content type = %q", ct)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// readFrame reads the next frame, skipping comments.
+func readFrame(t *testing.T, r *bufio.Reader) frame {
+	t.Helper()
+	var f frame
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("could not read frame: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if f != (frame{}) {
+				return f
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			f.id = value
+		case "event":
+			f.event = value
+		case "data":
+			f.data = value
+		case "retry":
+			f.retry = value
+		}
+	}
+}
+
+func TestInsightsStream(t *testing.T) {
+	s, store := newTestServer(t)
+	// closed after the streams, which are closed in cleanups registered later
+	ts := httptest.NewServer(http.HandlerFunc(s.InsightsStream))
+	t.Cleanup(ts.Close)
+
+	// published before anyone listens, kept for replay
+	s.Broker.Publish(api.InsightEvent{Name: "Habits", UserID: "user", Kind: api.EVENT_DONE, Data: json.RawMessage("{\n  \"a\": 1\n}")})
+
+	stream := openStream(t, ts.URL, "")
+	if f := readFrame(t, stream); f.retry == "" {
+		t.Errorf("first frame = %+v, want retry hint", f)
+	}
+	s.Broker.Publish(api.InsightEvent{Name: "Spirituality", UserID: "user", Kind: api.EVENT_DONE, At: time.Date(2025, 1, 2, 3, 4, 0, 0, time.UTC), Data: json.RawMessage(`{"b":2}`)})
+	s.Broker.Publish(api.InsightEvent{Name: "Spirituality", UserID: "user", Kind: api.EVENT_FAILED, Data: api.InsightProgress{Kind: api.EVENT_FAILED, Name: "Spirituality", Slug: "spirituality", Error: "timeout", At: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}})
+
+	// done events are sent named after the insight and as done
+	named := readFrame(t, stream)
+	if named.event != "Spirituality" || named.data != `{"b":2}` {
+		t.Errorf("named done frame = %+v", named)
+	}
+	done := readFrame(t, stream)
+	if done.id != named.id || done.event != "done" || done.data != `{"kind":"done","name":"Spirituality","slug":"spirituality","at":"2025-01-02T03:04:00Z","insight":{"b":2}}` {
+		t.Errorf("done frame = %+v", done)
+	}
+	failed := readFrame(t, stream)
+	if failed.event != "failed" || failed.data != `{"kind":"failed","name":"Spirituality","slug":"spirituality","error":"timeout","at":"2025-01-02T03:04:05Z"}` {
+		t.Errorf("failed frame = %+v", failed)
+	}
+	doneID, _ := strconv.ParseInt(done.id, 10, 64)
+	failedID, _ := strconv.ParseInt(failed.id, 10, 64)
+	if doneID == 0 || failedID <= doneID {
+		t.Errorf("event ids %q, %q are not increasing", done.id, failed.id)
+	}
+
+	// reconnecting replays everything after the last event seen
+	replay := openStream(t, ts.URL, done.id)
+	readFrame(t, replay)
+	if f := readFrame(t, replay); f.id != failed.id || f.event != "failed" {
+		t.Errorf("replayed frame = %+v, want %+v", f, failed)
+	}
+
+	// events of an earlier run are no longer buffered, the stored insights
+	// are sent instead
+	if err := store.UpsertInsight("user", "Habits", db.DONE, &db.InsightVersion{InsightJson: []byte(`{"c":3}`)}); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+	if err := store.MarkInsightStale("user", "Habits"); err != nil {
+		t.Fatalf("MarkInsightStale() failed: %v", err)
+	}
+	snapshot := openStream(t, ts.URL, "1")
+	readFrame(t, snapshot)
+	if f := readFrame(t, snapshot); f.event != "Habits" || f.data != `{"c":3}` || f.id != failed.id {
+		t.Errorf("snapshot frame = %+v", f)
+	}
+	if f := readFrame(t, snapshot); f.event != "done" || !strings.Contains(f.data, `"insight":{"c":3}`) {
+		t.Errorf("snapshot done frame = %+v", f)
+	}
+	if f := readFrame(t, snapshot); f.event != "stale" || !strings.Contains(f.data, `"slug":"habits"`) {
+		t.Errorf("snapshot stale frame = %+v", f)
+	}
+}
+
+func TestInsightsStream_MissedMoreThanBuffered(t *testing.T) {
+	s, store := newTestServer(t)
+	ts := httptest.NewServer(http.HandlerFunc(s.InsightsStream))
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := s.Broker.Subscribe(ctx, "user", 32, 0)
+	for i := range 25 {
+		s.Broker.Publish(api.InsightEvent{Name: "Habits", UserID: "user", Kind: api.EVENT_DONE, Data: json.RawMessage(strconv.Itoa(i))})
+	}
+	seen := <-sub.Events()
+	if err := store.UpsertInsight("user", "Habits", db.DONE, &db.InsightVersion{InsightJson: []byte(`24`)}); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+
+	// 24 missed events do not fit the buffer of the stream, the stored
+	// insights are sent instead and no older event follows them
+	stream := openStream(t, ts.URL, strconv.FormatInt(seen.ID, 10))
+	readFrame(t, stream)
+	if f := readFrame(t, stream); f.event != "Habits" || f.data != "24" {
+		t.Errorf("snapshot frame = %+v", f)
+	}
+	if f := readFrame(t, stream); f.event != "done" {
+		t.Errorf("snapshot done frame = %+v", f)
+	}
+	s.Broker.Publish(api.InsightEvent{Name: "Spirituality", UserID: "user", Kind: api.EVENT_DONE, Data: json.RawMessage(`{"b":2}`)})
+	if f := readFrame(t, stream); f.event != "Spirituality" || f.data != `{"b":2}` {
+		t.Errorf("frame after snapshot = %+v, want the next event", f)
+	}
+}
+
+func TestInsightsStream_Lifecycle(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := s.Broker.Subscribe(ctx, "user", 16, 0)
+
+	submitDimension(t, s, "Meaning & Purpose", 6)
+	for _, want := range []api.InsightEventKind{api.EVENT_QUEUED, api.EVENT_GENERATING, api.EVENT_DONE} {
+		select {
+		case ev := <-sub.Events():
+			if ev.Kind != want || ev.Name != "Meaning & Purpose" || ev.Slug != "meaning-purpose" || ev.At.IsZero() {
+				t.Errorf("event = %+v, want %s", ev, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+}
+
+func TestInsightsStream_Outbox(t *testing.T) {
+	store := db.NewMemoryStore()
+	if err := store.NewUser("user"); err != nil {
+		t.Fatalf("NewUser() failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// two instances sharing one store
+	var servers [2]*httptest.Server
+	var brokers [2]*api.OutboxBroker
+	for i := range servers {
+		broker, err := api.NewOutboxBroker(ctx, store, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("NewOutboxBroker() failed: %v", err)
+		}
+		s := &api.Server{Broker: broker, Store: store}
+		servers[i] = httptest.NewServer(http.HandlerFunc(s.InsightsStream))
+		t.Cleanup(servers[i].Close)
+		brokers[i] = broker
+	}
+
+	stream := openStream(t, servers[1].URL, "")
+	readFrame(t, stream)
+	brokers[0].Publish(api.InsightEvent{Name: "Habits", UserID: "user", Kind: api.EVENT_DONE, Data: json.RawMessage(`{"a":1}`)})
+	brokers[0].Publish(api.InsightEvent{Name: "Habits", UserID: "other", Kind: api.EVENT_DONE, Data: json.RawMessage(`{"b":2}`)})
+	brokers[0].Publish(api.InsightEvent{Name: "Spirituality", UserID: "user", Kind: api.EVENT_DONE, Data: json.RawMessage(`{"c":3}`)})
+
+	first := readFrame(t, stream)
+	if first.event != "Habits" || first.data != `{"a":1}` {
+		t.Errorf("frame published on another instance = %+v", first)
+	}
+	if f := readFrame(t, stream); f.event != "done" || !strings.Contains(f.data, `"insight":{"a":1}`) {
+		t.Errorf("done frame published on another instance = %+v", f)
+	}
+	second := readFrame(t, stream)
+	if second.event != "Spirituality" || second.data != `{"c":3}` {
+		t.Errorf("second frame = %+v", second)
+	}
+
+	// reconnecting to the other instance replays from the store
+	replay := openStream(t, servers[0].URL, first.id)
+	readFrame(t, replay)
+	if f := readFrame(t, replay); f != second {
+		t.Errorf("replayed frame = %+v, want %+v", f, second)
+	}
+
+	// so does the instance that delivered the events, which keeps no history
+	again := openStream(t, servers[1].URL, first.id)
+	readFrame(t, again)
+	if f := readFrame(t, again); f != second {
+		t.Errorf("replayed frame = %+v, want %+v", f, second)
+	}
+
+}
+
+func TestInsightsStream_OutboxMissedMoreThanBuffered(t *testing.T) {
+	store := db.NewMemoryStore()
+	if err := store.NewUser("user"); err != nil {
+		t.Fatalf("NewUser() failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	broker, err := api.NewOutboxBroker(ctx, store, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewOutboxBroker() failed: %v", err)
+	}
+	s := &api.Server{Broker: broker, Store: store}
+	ts := httptest.NewServer(http.HandlerFunc(s.InsightsStream))
+	t.Cleanup(ts.Close)
+
+	for i := range 20 {
+		broker.Publish(api.InsightEvent{Name: "Habits", UserID: "user", Kind: api.EVENT_DONE, Data: json.RawMessage(strconv.Itoa(i))})
+	}
+	if err := store.UpsertInsight("user", "Habits", db.DONE, &db.InsightVersion{InsightJson: []byte(`19`)}); err != nil {
+		t.Fatalf("UpsertInsight() failed: %v", err)
+	}
+
+	// 19 missed events do not fit the buffer of the stream
+	stream := openStream(t, ts.URL, "1")
+	readFrame(t, stream)
+	if f := readFrame(t, stream); f.event != "Habits" || f.data != "19" {
+		t.Errorf("snapshot frame = %+v", f)
+	}
+	if f := readFrame(t, stream); f.event != "done" {
+		t.Errorf("snapshot done frame = %+v", f)
+	}
+	broker.Publish(api.InsightEvent{Name: "Spirituality", UserID: "user", Kind: api.EVENT_DONE, Data: json.RawMessage(`{"b":2}`)})
+	if f := readFrame(t, stream); f.event != "Spirituality" || f.data != `{"b":2}` {
+		t.Errorf("frame after snapshot = %+v, want the next event", f)
+	}
+}
@@ -1,5 +1,12 @@
 package api
 
+import (
+	"encoding/json"
+	"time"
+	"user-db/db"
+	"user-db/shared"
+)
+
 type ResponsePayload struct {
 	Answers []HttpAnswer `json:"answers"`
 }
@@ -10,4 +17,126 @@ type HttpAnswer struct {
 	Kind       string `json:"kind"`
 }
 
+type SubmitResult struct {
+	Success bool          `json:"success"`
+	Error   string        `json:"error,omitempty"`
+	Errors  []AnswerError `json:"errors,omitempty"`
+}
+
+// AnswerError describes why a single answer of a submission was rejected.
+type AnswerError struct {
+	Index      int    `json:"index"`
+	QuestionID int    `json:"questionid"`
+	Code       string `json:"code"`
+	Error      string `json:"error"`
+}
+
+type AnswerHistory struct {
+	QuestionID int              `json:"questionId"`
+	History    []db.AnswerEvent `json:"history"`
+}
+
+type Progress struct {
+	Dimensions []DimensionProgress `json:"dimensions"`
+	// NextDimension is the dimension the next questions are picked from, empty
+	// while general questions are open or everything is answered
+	NextDimension  string           `json:"nextDimension"`
+	HolisticStatus db.InsightStatus `json:"holisticStatus,omitempty"`
+	// HolisticAvailable is set once the holistic insight was generated, and
+	// stays set while it is regenerated
+	HolisticAvailable bool `json:"holisticAvailable"`
+}
+
+type DimensionProgress struct {
+	Name          string                 `json:"name"`
+	Slug          string                 `json:"slug"`
+	Answered      int                    `json:"answered"`
+	Total         int                    `json:"total"`
+	Complete      bool                   `json:"complete"`
+	SubDimensions []SubDimensionProgress `json:"subDimensions"`
+	// InsightStatus is empty if no insight was generated yet
+	InsightStatus db.InsightStatus `json:"insightStatus,omitempty"`
+	// InsightAvailable is set once the insight was generated, and stays set
+	// while it is regenerated
+	InsightAvailable bool `json:"insightAvailable"`
+}
+
+type SubDimensionProgress struct {
+	Name     string `json:"name"`
+	Answered int    `json:"answered"`
+	Total    int    `json:"total"`
+}
+
+// NOT_STARTED is the status listed for insights that were never generated.
+const NOT_STARTED db.InsightStatus = "NOT_STARTED"
+
+type InsightList struct {
+	Insights []InsightSummary `json:"insights"`
+}
+
+// InsightSummary is the state of an insight of the user.
+type InsightSummary struct {
+	Name   string           `json:"name"`
+	Slug   string           `json:"slug"`
+	Status db.InsightStatus `json:"status"`
+	// Error is the reason of the failure while FAILED
+	Error string `json:"error,omitempty"`
+	// Stale is set when the answers changed since the latest version
+	Stale bool `json:"stale"`
+	// Version is the number of the latest version, 0 if there is none
+	Version int `json:"version"`
+	// CreatedAt and UpdatedAt are null if not known
+	CreatedAt   *time.Time      `json:"createdAt"`
+	UpdatedAt   *time.Time      `json:"updatedAt"`
+	InsightJson json.RawMessage `json:"insightJson,omitempty"`
+}
+
+// ErrorResponse is the body of failed requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// InsightDone is the payload of the done events of the insights stream.
+type InsightDone struct {
+	Kind    InsightEventKind `json:"kind"`
+	Name    string           `json:"name"`
+	Slug    string           `json:"slug"`
+	At      time.Time        `json:"at"`
+	Insight json.RawMessage  `json:"insight"`
+}
+
+// InsightProgress is the payload of the insights stream events other than
+// done.
+type InsightProgress struct {
+	Kind InsightEventKind `json:"kind"`
+	Name string           `json:"name"`
+	Slug string           `json:"slug"`
+	// Error is the reason of the failure of failed events
+	Error string    `json:"error,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// InsightJob is the state of a queued insight generation.
+type InsightJob struct {
+	ID        string       `json:"id"`
+	Insight   string       `json:"insight"`
+	Status    db.JobStatus `json:"status"`
+	Attempts  int          `json:"attempts"`
+	Error     string       `json:"error,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+type InsightVersions struct {
+	Name     string              `json:"name"`
+	Versions []db.InsightVersion `json:"versions"`
+}
+
+type InsightDiff struct {
+	Name    string          `json:"name"`
+	From    int             `json:"from"`
+	To      int             `json:"to"`
+	Changes []shared.Change `json:"changes"`
+}
+
 type contextKey string
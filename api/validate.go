@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"user-db/db"
+	"user-db/questions"
+	"user-db/shared"
+)
+
+// error codes of rejected answers
+const (
+	UNKNOWN_QUESTION   string = "UNKNOWN_QUESTION"
+	INVALID_KIND       string = "INVALID_KIND"
+	ANSWER_REQUIRED    string = "ANSWER_REQUIRED"
+	OUT_OF_SCALE       string = "OUT_OF_SCALE"
+	DUPLICATE_QUESTION string = "DUPLICATE_QUESTION"
+)
+
+// validateAnswers checks every answer of a submission against the loaded
+// question bank before anything is written. It returns the answers ready to
+// be stored, or one error per invalid answer.
+func validateAnswers(answers []HttpAnswer) ([]db.AnswerUpdate, []AnswerError) {
+	var updates []db.AnswerUpdate
+	var answerErrs []AnswerError
+
+	qs := questions.GetQuestions()
+	seen := make(map[int]bool, len(answers))
+	for i, answer := range answers {
+		fail := func(code string, format string, args ...any) {
+			answerErrs = append(answerErrs, AnswerError{
+				Index:      i,
+				QuestionID: answer.QuestionID,
+				Code:       code,
+				Error:      fmt.Sprintf(format, args...),
+			})
+		}
+
+		question, ok := qs[answer.QuestionID]
+		if !ok {
+			fail(UNKNOWN_QUESTION, "unknown question %d", answer.QuestionID)
+			continue
+		}
+		kind, err := shared.ToAnswerKind(answer.Kind)
+		if err != nil {
+			fail(INVALID_KIND, "%s", err)
+			continue
+		}
+		switch kind {
+		case shared.DONTKNOW:
+			if !question.Policy.AllowsDontKnow() {
+				fail(ANSWER_REQUIRED, "question %d needs an answer, %s is not allowed", answer.QuestionID, kind)
+				continue
+			}
+		case shared.SCALE:
+			if !question.InScale(answer.Value) {
+				fail(OUT_OF_SCALE, "value %d of question %d is outside of scale %d to %d", answer.Value, answer.QuestionID, question.ScaleMin, question.ScaleMax)
+				continue
+			}
+		}
+		if seen[answer.QuestionID] {
+			fail(DUPLICATE_QUESTION, "question %d answered more than once", answer.QuestionID)
+			continue
+		}
+		seen[answer.QuestionID] = true
+
+		updates = append(updates, db.AnswerUpdate{
+			QuestionID: answer.QuestionID,
+			Kind:       kind,
+			Value:      answer.Value,
+		})
+	}
+	return updates, answerErrs
+}
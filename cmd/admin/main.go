@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"user-db/db"
+	"user-db/questions"
 	"user-db/shared"
 )
 
@@ -14,41 +17,68 @@ func main() {
 		fmt.Println("Usage: admin <command> [arguments]")
 		fmt.Println("\nAvailable Commands:")
 		fmt.Println("  delete-questions           Delete all questions ")
-		fmt.Println("  import-questions        Seed the database with initial question data")
+		fmt.Println("  import-questions [csv-file]        Import questions as a new revision (default: embedded questions.csv)")
 		fmt.Println("  get-question <question-id>          Get question metadata")
+		fmt.Println("  lint-questions [csv-file]        Check questions for errors (default: embedded questions.csv)")
 		fmt.Println("  get <user-id>          Get questions and answers for the specified user")
 		fmt.Println("  create-user <user-id>          create a new user with the specified user-id")
 		fmt.Println("  delete-user <user-id>  delete user with specified user-id")
 		fmt.Println("  add-answer <user-id>  <question-id> <value>         add an answer for user with question-id and value")
+		fmt.Println("  migrate <legacy-csv>   move answers from row-based question IDs of a legacy questions.csv to the question IDs")
 		os.Exit(1)
 	}
 
+	// commands without database
 	switch os.Args[1] {
+	case "lint-questions":
+		lintQuestions(os.Args[2:])
+		return
+	}
+
+	// never the in-memory store, changes would be lost silently
+	store, err := db.NewStore("mongo", os.Getenv("MONGODB_URI"))
+	if err != nil {
+		log.Fatalf("Error opening store: %s", err)
+	}
+
+	switch os.Args[1] {
+	case "import-questions":
+		importQuestions(store, os.Args[2:])
+	case "delete-questions":
+		deleteQuestions(store)
+	case "get-question":
+		getQuestion(store, os.Args[2:])
 	case "get":
-		getAnswersForUser(os.Args[2])
+		getAnswersForUser(store, os.Args[2])
 	case "create-user":
-		createUser(os.Args[2])
+		createUser(store, os.Args[2])
 	case "delete-user":
-		deleteUser(os.Args[2])
+		deleteUser(store, os.Args[2])
 	case "add-answer":
-		addAnswer(os.Args[2:])
+		addAnswer(store, os.Args[2:])
+	case "migrate":
+		migrate(store, os.Args[2:])
 	default:
 		fmt.Printf("Unknown command: %s\n", os.Args[1])
 		os.Exit(1)
 	}
 }
 
-func createUser(userId string) {
+func createUser(store db.UserStore, userId string) {
 	log.Printf("Creating new user with ID: %s", userId)
-	db.NewUser(userId)
+	if err := store.NewUser(userId); err != nil {
+		log.Fatalf("Error creating user (%s): %v", userId, err)
+	}
 }
 
-func deleteUser(userId string) {
+func deleteUser(store db.UserStore, userId string) {
 	log.Printf("Deleting user with ID: %s", userId)
-	db.DeleteUser(userId)
+	if err := store.DeleteUser(userId); err != nil {
+		log.Fatalf("Error deleting user (%s): %v", userId, err)
+	}
 }
 
-func addAnswer(args []string) {
+func addAnswer(store db.UserStore, args []string) {
 	if len(args) != 3 {
 		fmt.Println("Usage: admin add-answer <user-id> <question-id> <value>")
 		os.Exit(1)
@@ -65,15 +95,172 @@ func addAnswer(args []string) {
 		os.Exit(1)
 	}
 	log.Printf("Adding answer for user %s: question-id=%d, value=%d", userId, questionId, value)
-	db.UpsertAnswer(userId, questionId, shared.SCALE, value)
+	if err := store.UpsertAnswer(userId, questionId, shared.SCALE, value); err != nil {
+		log.Fatalf("Error adding answer for user (%s): %v", userId, err)
+	}
 }
 
-func getAnswersForUser(userId string) {
+func getAnswersForUser(store db.UserStore, userId string) {
 
-	userAnswers, err := db.GetUser(userId)
+	userAnswers, err := store.GetUser(userId)
 	if err != nil {
 		log.Printf("Error getting user (%s): %v", userId, err)
 		os.Exit(1)
 	}
 	log.Printf("UserAnswers for user %s: %v", userId, userAnswers)
 }
+
+func importQuestions(store db.QuestionStore, args []string) {
+	qs, err := questions.ParseCSV(readQuestionsCSV(args))
+	if err != nil {
+		log.Fatalf("Error parsing questions: %v", err)
+	}
+
+	bank, err := store.ImportQuestions(qs)
+	if err != nil {
+		log.Fatalf("Error importing questions: %v", err)
+	}
+	log.Printf("Imported %d questions as revision %d", len(bank.Questions), bank.Revision)
+}
+
+func deleteQuestions(store db.QuestionStore) {
+	fmt.Print("This deletes all question revisions. Type 'yes' to continue: ")
+	var confirmation string
+	fmt.Scanln(&confirmation)
+	if confirmation != "yes" {
+		fmt.Println("Aborted")
+		os.Exit(1)
+	}
+
+	if err := store.DeleteQuestions(); err != nil {
+		log.Fatalf("Error deleting questions: %v", err)
+	}
+	log.Printf("Deleted all questions")
+}
+
+func getQuestion(store db.QuestionStore, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: admin get-question <question-id>")
+		os.Exit(1)
+	}
+	questionId, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Printf("Could not convert question-id to int: %s\n", args[0])
+		os.Exit(1)
+	}
+
+	question, err := store.GetQuestion(questionId)
+	if err != nil {
+		log.Fatalf("Error getting question %d: %v", questionId, err)
+	}
+	out, _ := json.MarshalIndent(question, "", "  ")
+	fmt.Println(string(out))
+}
+
+// migrate moves answers stored under the row numbers of a questions.csv
+// without id column to the stable question IDs. Run it only once per legacy
+// file, a second run would remap already migrated answers, and with the API
+// stopped, as later answers would keep their row numbers.
+func migrate(store db.Store, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: admin migrate <legacy-csv>")
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", args[0], err)
+	}
+	legacy, err := questions.ParseLegacyCSV(data)
+	if err != nil {
+		log.Fatalf("Error parsing legacy questions: %v", err)
+	}
+
+	if err := questions.Load("store", store); err != nil {
+		log.Fatalf("Error loading questions: %v", err)
+	}
+	current := shared.MapToSlice(questions.GetQuestions())
+
+	mapping, unmatched := questions.MigrationMapping(legacy, current)
+	moved := 0
+	for oldID, newID := range mapping {
+		if oldID != newID {
+			moved++
+		}
+	}
+	fmt.Printf("%d of %d legacy questions change their ID\n", moved, len(legacy))
+	for _, q := range unmatched {
+		fmt.Printf("no match for legacy question %d, its answers will be dropped: %s\n", q.ID, q.Text)
+	}
+
+	fmt.Print("With the API stopped, type 'yes' to migrate the answers of all users: ")
+	var confirmation string
+	fmt.Scanln(&confirmation)
+	if confirmation != "yes" {
+		fmt.Println("Aborted")
+		os.Exit(1)
+	}
+
+	userIDs, err := store.ListUserIDs()
+	if err != nil {
+		log.Fatalf("Error listing users: %v", err)
+	}
+	for _, userID := range userIDs {
+		dropped, err := migrateUser(store, userID, mapping)
+		if err != nil {
+			log.Fatalf("Error migrating user (%s): %v", userID, err)
+		}
+		if len(dropped) > 0 {
+			log.Printf("Dropped answers of user %s for legacy questions %v", userID, dropped)
+		}
+	}
+	log.Printf("Migrated %d users", len(userIDs))
+}
+
+// migrateUser remaps the answers of a user. Answers submitted meanwhile are
+// not overwritten; the user is read and remapped again instead.
+func migrateUser(store db.Store, userID string, mapping map[int]int) ([]int, error) {
+	for {
+		ua, err := store.GetUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		answers, dropped := ua.RemapAnswers(mapping)
+		err = store.ReplaceAnswers(userID, ua.AnswersRevision, answers)
+		if !errors.Is(err, db.ErrAnswersChanged) {
+			return dropped, err
+		}
+		log.Printf("Answers of user %s changed during the migration, migrating them again", userID)
+	}
+}
+
+func lintQuestions(args []string) {
+	issues := questions.Lint(readQuestionsCSV(args))
+	errors := 0
+	for _, issue := range issues {
+		fmt.Println(issue)
+		if !issue.Warning {
+			errors++
+		}
+	}
+	if errors > 0 {
+		fmt.Printf("%d issues found\n", errors)
+		os.Exit(1)
+	}
+	if len(issues) > 0 {
+		fmt.Printf("No issues found, %d warnings\n", len(issues))
+		return
+	}
+	fmt.Println("No issues found")
+}
+
+// readQuestionsCSV reads the file given as first argument, or the embedded questions.csv.
+func readQuestionsCSV(args []string) []byte {
+	if len(args) == 0 {
+		return questions.EmbeddedCSV()
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", args[0], err)
+	}
+	return data
+}
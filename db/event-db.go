@@ -0,0 +1,34 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EVENT_RETENTION is how long stored events are kept for replay.
+const EVENT_RETENTION = 24 * time.Hour
+
+// Event is an insight event in the outbox, shared by all server instances.
+type Event struct {
+	// ID is assigned by AppendEvent and increases with every event
+	ID     int64  `json:"id" bson:"_id"`
+	UserID string `json:"userId"`
+	Name   string `json:"name"`
+	// Kind is the lifecycle step of the insight, e.g. "done"
+	Kind      string          `json:"kind"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// EventStore is an outbox of insight events, polled by every instance to
+// deliver events to the streams connected to it.
+type EventStore interface {
+	// AppendEvent stores an event with the next ID and returns it.
+	AppendEvent(ev Event) (Event, error)
+	// EventsAfter returns up to limit events with an ID above after, oldest
+	// first. An empty userID returns the events of all users.
+	EventsAfter(userID string, after int64, limit int) ([]Event, error)
+	// EventBounds returns the IDs of the oldest and the newest stored event,
+	// both 0 if there is none.
+	EventBounds() (first int64, last int64, err error)
+}
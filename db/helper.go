@@ -1,12 +1,23 @@
 package db
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"slices"
 	"sort"
 	"strconv"
+	"time"
 	"user-db/shared"
 )
 
+// IsDontKnow reports whether the user chose "don't know". The value of such
+// an answer is a placeholder and must not be scored.
+func (ae AnswerEvent) IsDontKnow() bool {
+	return ae.Kind == shared.DONTKNOW.String()
+}
+
 func (ua *UserAnswers) GetLatestAnswer(questionID int) *AnswerEvent {
 
 	if qa, ok := ua.Answers[questionID]; ok {
@@ -15,82 +26,59 @@ func (ua *UserAnswers) GetLatestAnswer(questionID int) *AnswerEvent {
 	return nil
 }
 
-func (ua *UserAnswers) SortByDimension(qs []shared.Question, dims map[string]shared.Dimension) []shared.CatVal {
-
-	dimsToQuestions := make(map[string][]int)
-
-	for _, question := range qs {
-		answer := ua.GetLatestAnswer(question.ID)
-		if answer != nil {
-			dimsToQuestions[question.Dimension] = append(dimsToQuestions[question.Dimension], *answer.Value)
-		}
+// GetAnswerHistory returns all answers given to a question, oldest first.
+// Answers stored before the history was recorded only have a latest answer,
+// which is then returned as the only entry.
+func (ua *UserAnswers) GetAnswerHistory(questionID int) []AnswerEvent {
+	qa, ok := ua.Answers[questionID]
+	if !ok {
+		return []AnswerEvent{}
 	}
-
-	var sortedDimensions []shared.CatVal
-	for dimName, v := range dimsToQuestions {
-		sortedDimensions = append(sortedDimensions, shared.CatVal{CatType: shared.DimensionType, Name: dimName, Value: avg(v)})
+	if len(qa.History) == 0 {
+		return []AnswerEvent{qa.LatestAnswer}
 	}
-
-	// Sort by Value (ascending)
-	sort.Slice(sortedDimensions, func(i, j int) bool {
-
-		if sortedDimensions[i].Value != sortedDimensions[j].Value {
-			return sortedDimensions[i].Value < sortedDimensions[j].Value
-		}
-		// if equal rating, prioritise specific dimensions
-		return dims[sortedDimensions[i].Name].Rank < dims[sortedDimensions[j].Name].Rank
-	})
-
-	return sortedDimensions
+	return qa.History
 }
 
-func (ua *UserAnswers) SortByFacet(qs []shared.Question) []shared.CatVal {
-
-	facetsToQuestions := make(map[string][]int)
-
-	for _, question := range qs {
-		if question.Facet != shared.GENERAL {
-			answer := ua.GetLatestAnswer(question.ID)
-			if answer != nil {
-				facetsToQuestions[question.SubDimension+"."+question.Facet] = append(facetsToQuestions[question.Facet], *answer.Value)
-			}
+// GetAnswerAsOf returns the answer that was current at the given time, or nil
+// if the question had not been answered yet.
+func (ua *UserAnswers) GetAnswerAsOf(questionID int, at time.Time) *AnswerEvent {
+	var current *AnswerEvent
+	for _, ae := range ua.GetAnswerHistory(questionID) {
+		if ae.UpdatedAt.After(at) {
+			break
 		}
+		current = &ae
 	}
-
-	var sortedFacets []shared.CatVal
-	for subDimDotFacet, v := range facetsToQuestions {
-		sortedFacets = append(sortedFacets, shared.CatVal{CatType: shared.FacetType, Name: subDimDotFacet, Value: avg(v)})
-	}
-
-	// Sort by Value (ascending)
-	sort.Slice(sortedFacets, func(i, j int) bool {
-		return sortedFacets[i].Value < sortedFacets[j].Value
-	})
-
-	return sortedFacets
+	return current
 }
 
-func (ua *UserAnswers) DimensionRatingsToString(dimensionName string, dimensions map[string]shared.Dimension) string {
-
-	var result string
-
-	for dk, dims := range dimensions {
-		if dk == dimensionName {
-			for sk, subdims := range dims.SubDimensions {
-				result += sk + ":\n"
-				for fk, facet := range subdims.Facets {
-					qs := []int{}
-					for _, q := range facet.Questions {
-						qs = append(qs, *ua.GetLatestAnswer(q.ID).Value)
-					}
-					facetAvg := avg(qs)
-					result += fk + ": " + strconv.Itoa(facetAvg)
-				}
-			}
+// GetAnswersAsOf returns the answers of all questions as they were at the given time.
+func (ua *UserAnswers) GetAnswersAsOf(at time.Time) map[int]AnswerEvent {
+	result := make(map[int]AnswerEvent)
+	for questionID := range ua.Answers {
+		if ae := ua.GetAnswerAsOf(questionID, at); ae != nil {
+			result[questionID] = *ae
 		}
 	}
 	return result
+}
 
+// RemapAnswers moves every answer from its old question ID to the new one
+// given by mapping. Answers of questions missing in mapping are dropped and
+// their IDs returned.
+func (ua *UserAnswers) RemapAnswers(mapping map[int]int) (answers map[int]QuestionAnswers, dropped []int) {
+	answers = make(map[int]QuestionAnswers, len(ua.Answers))
+	for oldID, qa := range ua.Answers {
+		newID, ok := mapping[oldID]
+		if !ok {
+			dropped = append(dropped, oldID)
+			continue
+		}
+		answers[newID] = qa
+	}
+	sort.Ints(dropped)
+	return answers, dropped
 }
 
 func (ua *UserAnswers) HasInsight(insightName string) bool {
@@ -101,9 +89,18 @@ func (ua *UserAnswers) HasInsight(insightName string) bool {
 	return false
 }
 
+// NeedsInsight reports whether an insight has to be generated, because it
+// does not exist yet or its last generation failed.
 func (ua *UserAnswers) NeedsInsight(insightName string) bool {
-	_, ok := ua.Insights[insightName]
-	return !ok
+	insight, ok := ua.Insights[insightName]
+	return !ok || insight.Status == FAILED
+}
+
+// HasGeneratedInsight reports whether an insight has a version to show.
+// Unlike HasInsight it stays true while the insight is regenerated and after
+// its regeneration failed.
+func (ua *UserAnswers) HasGeneratedInsight(insightName string) bool {
+	return len(ua.Insights[insightName].InsightJson) > 0
 }
 
 func (ua *UserAnswers) GetInsight(insightName string) json.RawMessage {
@@ -111,20 +108,77 @@ func (ua *UserAnswers) GetInsight(insightName string) json.RawMessage {
 	return v.InsightJson
 }
 
-func avg(values []int) (total int) {
-	for _, value := range values {
-		total += value
+// InsightFingerprint returns the fingerprint of the latest version of an
+// insight, empty if there is none or it was generated before fingerprints.
+func (ua *UserAnswers) InsightFingerprint(insightName string) string {
+	versions := ua.Insights[insightName].Versions
+	if len(versions) == 0 {
+		return ""
 	}
-	return total / len(values)
+	return versions[len(versions)-1].Fingerprint
 }
 
-func (ua *UserAnswers) GetSorted(qs map[int]shared.Question, dims map[string]shared.Dimension) (sortedDims []shared.CatVal, sortedFacets []shared.CatVal) {
+// Fingerprint hashes the latest answers to the given questions. It changes
+// whenever one of these answers changes and is independent of the order of
+// questionIDs.
+func (ua *UserAnswers) Fingerprint(questionIDs []int) string {
+	ids := slices.Sorted(slices.Values(questionIDs))
+	h := sha256.New()
+	for _, id := range ids {
+		answer := ua.GetLatestAnswer(id)
+		if answer == nil {
+			fmt.Fprintf(h, "%d:-;", id)
+			continue
+		}
+		value := "-"
+		if answer.Value != nil {
+			value = strconv.Itoa(*answer.Value)
+		}
+		fmt.Fprintf(h, "%d:%s:%s;", id, answer.Kind, value)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
 
-	// convert questions to list of questions
-	questionsList := shared.MapToSlice(qs)
+// LatestVersion returns the number of the latest version of an insight,
+// 0 if none was generated.
+func (i Insight) LatestVersion() int {
+	// insights stored before versions were counted were never capped
+	return max(i.VersionCount, len(i.Versions))
+}
 
-	sortedDims = ua.SortByDimension(questionsList, dims)
-	sortedFacets = ua.SortByFacet(questionsList)
+// GetInsightVersions returns the kept versions of an insight, oldest first.
+// Insights stored before versioning are returned as a single version.
+func (ua *UserAnswers) GetInsightVersions(insightName string) []InsightVersion {
+	insight, ok := ua.Insights[insightName]
+	if !ok {
+		return []InsightVersion{}
+	}
+	if len(insight.Versions) == 0 {
+		if insight.InsightJson == nil {
+			return []InsightVersion{}
+		}
+		return []InsightVersion{{Version: 1, InsightJson: insight.InsightJson}}
+	}
+	// the oldest kept version, later than 1 once versions were dropped
+	first := insight.LatestVersion() - len(insight.Versions) + 1
+	versions := make([]InsightVersion, len(insight.Versions))
+	for i, v := range insight.Versions {
+		v.Version = first + i
+		versions[i] = v
+	}
+	return versions
+}
 
-	return sortedDims, sortedFacets
+// GetInsightVersion returns a single version of an insight, counting from 1.
+// Versions dropped by MAX_INSIGHT_VERSIONS are not found.
+func (ua *UserAnswers) GetInsightVersion(insightName string, version int) (InsightVersion, bool) {
+	versions := ua.GetInsightVersions(insightName)
+	if len(versions) == 0 {
+		return InsightVersion{}, false
+	}
+	i := version - versions[0].Version
+	if i < 0 || i >= len(versions) {
+		return InsightVersion{}, false
+	}
+	return versions[i], true
 }
@@ -1,105 +1,92 @@
 package db_test
 
 import (
-	"fmt"
-	"strings"
 	"testing"
+	"time"
 	"user-db/db"
-	"user-db/questions"
 	"user-db/shared"
-	"user-db/test"
 )
 
-func TestUserAnswers_GetSorted(t *testing.T) {
-	tests := []struct {
-		name string // description of this test case
-		// Named input parameters for receiver constructor.
-		userID string
-		// Named input parameters for target function.
-		userAnswers db.UserAnswers
-		qs          map[int]shared.Question
-		dims        map[string]shared.Dimension
-		want        []string
-		want2       []string
-	}{
-		{
-			name:   "all-answers",
-			userID: "all-answers",
-			userAnswers: db.UserAnswers{
-				UserID:  "all-answers-5",
-				Answers: test.AllAnswers5And([]int{}),
+func TestUserAnswers_GetAnswerAsOf(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	event := func(d, v int) db.AnswerEvent {
+		return db.AnswerEvent{Kind: shared.SCALE.String(), Value: &v, UpdatedAt: day(d)}
+	}
+
+	ua := db.UserAnswers{
+		Answers: map[int]db.QuestionAnswers{
+			1: {
+				LatestAnswer: event(20, 9),
+				History:      []db.AnswerEvent{event(10, 3), event(15, 6), event(20, 9)},
 			},
-			qs:   questions.GetQuestions(),
-			dims: questions.GetDimensions(),
-			want: []string{"Physical Health", "Mental Health", "Social Relationships", "Character & Virtue", "Meaning & Purpose", "Spirituality", "Material Stability", "Happiness & Life Satisfaction"},
-			// Fails cause it is non deterministic
-			want2: []string{"Boundaries.Assertive Limit-Setting",
-				"Financial Planning.Saving & Investing",
-				"Awe & Transcendence.Connection ",
-				"Boundaries.Enforcing Boundaries",
-				"Values & Authenticity.Values–Action Congruence",
-				"Awe & Transcendence.Contemplation",
-				"Sleep.circadian rhythm",
-				"Emotion Regulation.Awareness & Labeling",
-				"Sleep.Sleep quality",
-				"Cognitive Control.Sustained Attention",
-				"Sleep.alertness",
-				"Activity.Aerobic",
-				"Boundaries.Personal Autonomy",
-				"Communication.Active Listening and Empathy",
-				"Financial Planning.Debt Management",
-				"Connection.Social Integration",
-				"Activity.Strength",
-				"Cognitive Control.Goal Maintenance",
-				"Values & Authenticity.Courageous Authenticity",
-				"Financial Planning.Cashflow Plan & Tracking",
-				"Awe & Transcendence.Guiding Beliefs",
-				"Emotion Regulation.Acceptance",
-				"Financial Planning.Liquidity",
-				"Emotion Regulation.Reappraisal ",
-				"Communication.Open and Honest Expression",
-				"Connection.Belonging",
-				"Values & Authenticity.Values Clarity",
-				"Cognitive Control.Inhibitory Control",
-				"Values & Authenticity.Identity Coherence",
-				"Boundaries.Emotional Boundaries",
-				"Connection.Emotional Support",
-				"Financial Planning.Payments Reliability",
-				"Activity.Sedentary Behaviour",
-				"Awe & Transcendence.Wonder"},
+			// answered before history was recorded
+			2: {LatestAnswer: event(5, 4)},
 		},
 	}
+
+	tests := []struct {
+		name       string
+		questionID int
+		at         time.Time
+		want       *int
+	}{
+		{name: "before-first-answer", questionID: 1, at: day(9), want: nil},
+		{name: "first-answer", questionID: 1, at: day(10), want: ptr(3)},
+		{name: "between-answers", questionID: 1, at: day(17), want: ptr(6)},
+		{name: "latest-answer", questionID: 1, at: day(30), want: ptr(9)},
+		{name: "legacy-latest-only", questionID: 2, at: day(6), want: ptr(4)},
+		{name: "unanswered", questionID: 3, at: day(30), want: nil},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-
-			got, got2 := tt.userAnswers.GetSorted(tt.qs, tt.dims)
-			if err := compareDimensionToCatVal(got, tt.want); err != nil {
-				t.Errorf("GetSorted() Dimensions = %v", err)
+			got := ua.GetAnswerAsOf(tt.questionID, tt.at)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("GetAnswerAsOf() = %v, want nil", *got.Value)
+				}
+				return
 			}
-			if err := compareFacetsToCatVal(got2, tt.want2); err != nil {
-				t.Errorf("GetSorted() Facets = %v", err)
+			if got == nil || *got.Value != *tt.want {
+				t.Errorf("GetAnswerAsOf() = %v, want %v", got, *tt.want)
 			}
 		})
 	}
 }
 
-func compareDimensionToCatVal(got []shared.CatVal, want []string) error {
-	for i, v := range got {
-		// get rid of Dimension prefix
-		nameWOPrefix, _ := strings.CutPrefix(v.Name, "Dimension ")
-		if nameWOPrefix != want[i] {
-			return fmt.Errorf("%s unequal to %s", nameWOPrefix, want[i])
-		}
+func TestUserAnswers_Fingerprint(t *testing.T) {
+	answer := func(kind shared.AnswerKind, v int) db.QuestionAnswers {
+		return db.QuestionAnswers{LatestAnswer: db.AnswerEvent{Kind: kind.String(), Value: &v}}
+	}
+	ua := db.UserAnswers{Answers: map[int]db.QuestionAnswers{
+		1: answer(shared.SCALE, 3),
+		2: answer(shared.SCALE, 7),
+		3: answer(shared.SCALE, 1),
+	}}
+	base := ua.Fingerprint([]int{1, 2})
+
+	if got := ua.Fingerprint([]int{2, 1}); got != base {
+		t.Errorf("Fingerprint() depends on the order of the questions")
+	}
+
+	// answers to other questions do not matter
+	ua.Answers[3] = answer(shared.SCALE, 9)
+	if got := ua.Fingerprint([]int{1, 2}); got != base {
+		t.Errorf("Fingerprint() changed with an unrelated answer")
 	}
-	return nil
-}
-func compareFacetsToCatVal(got []shared.CatVal, want []string) error {
-	for i, v := range got {
 
-		nameWOPrefix, _ := strings.CutPrefix(v.Name, "Facet ")
-		if nameWOPrefix != want[i] {
-			return fmt.Errorf("%s unequal to %s", nameWOPrefix, want[i])
-		}
+	ua.Answers[2] = answer(shared.DONTKNOW, 7)
+	if got := ua.Fingerprint([]int{1, 2}); got == base {
+		t.Errorf("Fingerprint() unchanged after the kind of an answer changed")
 	}
-	return nil
+	ua.Answers[2] = answer(shared.SCALE, 8)
+	if got := ua.Fingerprint([]int{1, 2}); got == base {
+		t.Errorf("Fingerprint() unchanged after an answer changed")
+	}
+	if got := ua.Fingerprint([]int{1, 2, 4}); got == ua.Fingerprint([]int{1, 2}) {
+		t.Errorf("Fingerprint() unchanged with an unanswered question")
+	}
+}
+
+func ptr(v int) *int {
+	return &v
 }
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// JOB_RETENTION is how long finished jobs are kept before they are deleted.
+const JOB_RETENTION = 24 * time.Hour
+
+var ErrJobNotFound = errors.New("job not found")
+var ErrNoJobDue = errors.New("no job due")
+var ErrLeaseLost = errors.New("job lease lost")
+
+type JobStatus string
+
+const (
+	JOB_QUEUED  JobStatus = "queued"
+	JOB_RUNNING JobStatus = "running"
+	JOB_DONE    JobStatus = "done"
+	JOB_FAILED  JobStatus = "failed"
+)
+
+// Job generates one insight of a user in the background.
+type Job struct {
+	ID       string    `json:"id" bson:"_id"`
+	UserID   string    `json:"userId"`
+	Insight  string    `json:"insight"`
+	Status   JobStatus `json:"status"`
+	Attempts int       `json:"attempts"`
+	// Error is the reason of the last failed attempt
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// RunAfter delays a queued job, e.g. between retries
+	RunAfter time.Time `json:"runAfter"`
+	// LeaseUntil is when a running job is given up and claimed again
+	LeaseUntil time.Time `json:"leaseUntil"`
+	// Active is set while the job is queued or running. There is at most
+	// one active job per user and insight.
+	Active bool `json:"-"`
+}
+
+// JobStore persists insight jobs, so that they survive a restart.
+type JobStore interface {
+	// EnqueueJob queues a job for an insight of a user to run after
+	// runAfter. If a job for the insight is already queued or running, that
+	// job is returned instead; a queued job is postponed to runAfter.
+	EnqueueJob(userID string, insight string, runAfter time.Time) (Job, error)
+	// GetJob returns a job. Finished jobs are deleted after JOB_RETENTION.
+	GetJob(id string) (Job, error)
+	// ClaimJob leases the oldest due job: a queued job whose RunAfter has
+	// passed, or a running job whose lease has expired. It returns
+	// ErrNoJobDue if there is none.
+	ClaimJob(lease time.Duration) (Job, error)
+	// RetryJob queues a running job again to run after runAfter. attempt is
+	// the Attempts of the claimed job; if the job has been claimed again
+	// since, or is not running any more, ErrLeaseLost is returned.
+	RetryJob(id string, attempt int, runAfter time.Time, reason string) error
+	// RequeueJob queues a running job again to run after runAfter without
+	// counting the attempt, e.g. when its result was outdated. Like RetryJob,
+	// it returns ErrLeaseLost unless attempt still holds the lease.
+	RequeueJob(id string, attempt int, runAfter time.Time) error
+	// FinishJob ends a running job with JOB_DONE or JOB_FAILED. Like
+	// RetryJob, it returns ErrLeaseLost unless attempt still holds the lease.
+	FinishJob(id string, attempt int, status JobStatus, reason string) error
+}
+
+func newJob(userID, insight string, now, runAfter time.Time) Job {
+	return Job{
+		ID:        bson.NewObjectID().Hex(),
+		UserID:    userID,
+		Insight:   insight,
+		Status:    JOB_QUEUED,
+		CreatedAt: now,
+		UpdatedAt: now,
+		RunAfter:  runAfter,
+		Active:    true,
+	}
+}
+
+// Lease identifies the attempt of a claimed job, see UserStore.StartInsight.
+func (j Job) Lease() string {
+	return fmt.Sprintf("%s/%d", j.ID, j.Attempts)
+}
+
+// isDue reports whether ClaimJob may take the job.
+func (j Job) isDue(now time.Time) bool {
+	switch j.Status {
+	case JOB_QUEUED:
+		return !j.RunAfter.After(now)
+	case JOB_RUNNING:
+		return !j.LeaseUntil.After(now)
+	}
+	return false
+}
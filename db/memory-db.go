@@ -0,0 +1,449 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+	"user-db/shared"
+)
+
+// MemoryStore keeps all users in memory. It is meant for local development
+// and tests; nothing survives a restart.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	users map[string]UserAnswers
+	banks []QuestionBank
+	jobs  []Job
+	// events holds the latest MAX_MEMORY_EVENTS events, oldest first
+	events      []Event
+	lastEventID int64
+}
+
+// MAX_MEMORY_EVENTS bounds the events kept by a MemoryStore.
+const MAX_MEMORY_EVENTS = 1000
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{users: make(map[string]UserAnswers)}
+}
+
+func (m *MemoryStore) NewUser(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; ok {
+		return fmt.Errorf("user %s already exists", userID)
+	}
+	m.users[userID] = emptyUser(userID)
+	return nil
+}
+
+func (m *MemoryStore) GetUser(userID string) (UserAnswers, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ua, ok := m.users[userID]
+	if !ok {
+		return UserAnswers{}, ErrUserNotFound
+	}
+	return ua.clone(), nil
+}
+
+func (m *MemoryStore) ListUserIDs() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Sorted(maps.Keys(m.users)), nil
+}
+
+func (m *MemoryStore) DeleteUser(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	delete(m.users, userID)
+	log.Printf("deleted user: %v", userID)
+	return nil
+}
+
+func (m *MemoryStore) ResetUser(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[userID] = emptyUser(userID)
+	log.Printf("reset user: %v", userID)
+	return nil
+}
+
+func (m *MemoryStore) UpsertAnswer(userID string, questionID int, kind shared.AnswerKind, value int) error {
+	_, err := m.UpsertAnswers(userID, []AnswerUpdate{{QuestionID: questionID, Kind: kind, Value: value}})
+	return err
+}
+
+func (m *MemoryStore) UpsertAnswers(userID string, answers []AnswerUpdate) (UserAnswers, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ua, ok := m.users[userID]
+	if !ok {
+		return UserAnswers{}, ErrUserNotFound
+	}
+
+	now := time.Now()
+	for _, a := range answers {
+		value := a.Value
+		answer := AnswerEvent{
+			Kind:      a.Kind.String(),
+			Value:     &value,
+			UpdatedAt: now,
+		}
+		qa, ok := ua.Answers[a.QuestionID]
+		// answers stored before histories existed only have LatestAnswer
+		if ok && len(qa.History) == 0 {
+			qa.History = []AnswerEvent{qa.LatestAnswer}
+		}
+		qa.LatestAnswer = answer
+		qa.History = append(qa.History, answer)
+		ua.Answers[a.QuestionID] = qa
+	}
+	ua.AnswersRevision++
+	m.users[userID] = ua
+
+	return ua.clone(), nil
+}
+
+func (m *MemoryStore) ReplaceAnswers(userID string, revision int, answers map[int]QuestionAnswers) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ua, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if ua.AnswersRevision != revision {
+		return ErrAnswersChanged
+	}
+	ua.Answers = UserAnswers{Answers: answers}.clone().Answers
+	ua.AnswersRevision++
+	m.users[userID] = ua
+	return nil
+}
+
+func (m *MemoryStore) UpsertInsight(userID string, insightName string, status InsightStatus, version *InsightVersion) error {
+	if err := checkInsightVersion(status, version); err != nil {
+		return err
+	}
+	return m.update(userID, func(ua *UserAnswers) {
+		ua.Insights[insightName] = setInsight(ua.Insights[insightName], status, version)
+	})
+}
+
+func (m *MemoryStore) StartInsight(userID string, insightName string, lease string) error {
+	return m.update(userID, func(ua *UserAnswers) {
+		insight := setInsight(ua.Insights[insightName], GENERATING, nil)
+		insight.Lease = lease
+		ua.Insights[insightName] = insight
+	})
+}
+
+func (m *MemoryStore) FinishInsight(userID string, insightName string, lease string, version InsightVersion) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ua, ok := m.users[userID]
+	if !ok || ua.Insights[insightName].Lease != lease {
+		return ErrLeaseLost
+	}
+	insight := setInsight(ua.Insights[insightName], DONE, &version)
+	insight.Lease = ""
+	ua.Insights[insightName] = insight
+	return nil
+}
+
+// setInsight sets the status of an insight and appends version, if any.
+func setInsight(insight Insight, status InsightStatus, version *InsightVersion) Insight {
+	insight.Status = status
+	insight.Error = ""
+	insight.UpdatedAt = time.Now()
+	if insight.CreatedAt.IsZero() {
+		insight.CreatedAt = insight.UpdatedAt
+	}
+	if version != nil {
+		insight.Stale = false
+		v := version.clone()
+		insight.InsightJson = v.InsightJson
+		insight.VersionCount = insight.LatestVersion() + 1
+		insight.Versions = append(insight.Versions, v)
+		if n := len(insight.Versions); n > MAX_INSIGHT_VERSIONS {
+			insight.Versions = slices.Clone(insight.Versions[n-MAX_INSIGHT_VERSIONS:])
+		}
+	}
+	return insight
+}
+
+func (m *MemoryStore) FailInsight(userID string, insightName string, reason string) error {
+	return m.update(userID, func(ua *UserAnswers) {
+		insight := ua.Insights[insightName]
+		insight.Status = FAILED
+		insight.Error = reason
+		insight.UpdatedAt = time.Now()
+		if insight.CreatedAt.IsZero() {
+			insight.CreatedAt = insight.UpdatedAt
+		}
+		ua.Insights[insightName] = insight
+	})
+}
+
+func (m *MemoryStore) MarkInsightStale(userID string, insightName string) error {
+	return m.update(userID, func(ua *UserAnswers) {
+		insight := ua.Insights[insightName]
+		insight.Stale = true
+		insight.UpdatedAt = time.Now()
+		ua.Insights[insightName] = insight
+	})
+}
+
+func (m *MemoryStore) ImportQuestions(questions []shared.Question) (QuestionBank, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bank := QuestionBank{
+		Revision:   len(m.banks) + 1,
+		ImportedAt: time.Now(),
+		Questions:  slices.Clone(questions),
+	}
+	m.banks = append(m.banks, bank)
+	return bank, nil
+}
+
+func (m *MemoryStore) GetQuestionBank() (QuestionBank, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.banks) == 0 {
+		return QuestionBank{}, ErrNoQuestions
+	}
+	bank := m.banks[len(m.banks)-1]
+	bank.Questions = slices.Clone(bank.Questions)
+	return bank, nil
+}
+
+func (m *MemoryStore) GetQuestion(questionID int) (shared.Question, error) {
+	bank, err := m.GetQuestionBank()
+	if err != nil {
+		return shared.Question{}, err
+	}
+	return findQuestion(bank, questionID)
+}
+
+func (m *MemoryStore) DeleteQuestions() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.banks = nil
+	return nil
+}
+
+func (m *MemoryStore) EnqueueJob(userID string, insight string, runAfter time.Time) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, j := range m.jobs {
+		if j.Active && j.UserID == userID && j.Insight == insight {
+			if j.Status == JOB_QUEUED && runAfter.After(j.RunAfter) {
+				j.RunAfter = runAfter
+				m.jobs[i] = j
+			}
+			return j, nil
+		}
+	}
+	now := time.Now()
+	// finished jobs are only kept for clients polling them
+	m.jobs = slices.DeleteFunc(m.jobs, func(j Job) bool {
+		return !j.Active && now.Sub(j.UpdatedAt) > JOB_RETENTION
+	})
+	job := newJob(userID, insight, now, runAfter)
+	m.jobs = append(m.jobs, job)
+	return job, nil
+}
+
+func (m *MemoryStore) GetJob(id string) (Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, j := range m.jobs {
+		if j.ID == id {
+			return j, nil
+		}
+	}
+	return Job{}, ErrJobNotFound
+}
+
+func (m *MemoryStore) ClaimJob(lease time.Duration) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// jobs are kept in the order they were created
+	now := time.Now()
+	for i, j := range m.jobs {
+		if j.isDue(now) {
+			j.Status = JOB_RUNNING
+			j.Attempts++
+			j.LeaseUntil = now.Add(lease)
+			j.UpdatedAt = now
+			m.jobs[i] = j
+			return j, nil
+		}
+	}
+	return Job{}, ErrNoJobDue
+}
+
+func (m *MemoryStore) RetryJob(id string, attempt int, runAfter time.Time, reason string) error {
+	return m.updateJob(id, attempt, func(j *Job) {
+		j.Status = JOB_QUEUED
+		j.RunAfter = runAfter
+		j.Error = reason
+	})
+}
+
+func (m *MemoryStore) RequeueJob(id string, attempt int, runAfter time.Time) error {
+	return m.updateJob(id, attempt, func(j *Job) {
+		j.Status = JOB_QUEUED
+		j.RunAfter = runAfter
+		j.Attempts--
+	})
+}
+
+func (m *MemoryStore) FinishJob(id string, attempt int, status JobStatus, reason string) error {
+	return m.updateJob(id, attempt, func(j *Job) {
+		j.Status = status
+		j.Error = reason
+		j.Active = false
+	})
+}
+
+// updateJob updates a running job if attempt still holds its lease.
+func (m *MemoryStore) updateJob(id string, attempt int, fn func(j *Job)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, j := range m.jobs {
+		if j.ID == id && j.Attempts == attempt && j.Status == JOB_RUNNING {
+			fn(&m.jobs[i])
+			m.jobs[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrLeaseLost
+}
+
+func (m *MemoryStore) AppendEvent(ev Event) (Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastEventID++
+	ev.ID = m.lastEventID
+	ev.CreatedAt = time.Now()
+	ev.Data = slices.Clone(ev.Data)
+	m.events = append(m.events, ev)
+	if len(m.events) > MAX_MEMORY_EVENTS {
+		m.events = slices.Delete(m.events, 0, len(m.events)-MAX_MEMORY_EVENTS)
+	}
+	return ev, nil
+}
+
+func (m *MemoryStore) EventsAfter(userID string, after int64, limit int) ([]Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := []Event{}
+	for _, ev := range m.events {
+		if len(events) == limit {
+			break
+		}
+		if ev.ID > after && (userID == "" || ev.UserID == userID) {
+			ev.Data = slices.Clone(ev.Data)
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+func (m *MemoryStore) EventBounds() (int64, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.events) == 0 {
+		return 0, 0, nil
+	}
+	return m.events[0].ID, m.events[len(m.events)-1].ID, nil
+}
+
+// update applies fn to the stored user while holding the write lock.
+func (m *MemoryStore) update(userID string, fn func(ua *UserAnswers)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ua, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	fn(&ua)
+	m.users[userID] = ua
+	return nil
+}
+
+func emptyUser(userID string) UserAnswers {
+	return UserAnswers{
+		UserID:   userID,
+		Answers:  make(map[int]QuestionAnswers),
+		Insights: make(map[string]Insight),
+	}
+}
+
+// clone returns a deep copy so callers cannot modify the stored user.
+func (ua UserAnswers) clone() UserAnswers {
+	c := UserAnswers{
+		UserID:          ua.UserID,
+		Answers:         make(map[int]QuestionAnswers, len(ua.Answers)),
+		Insights:        make(map[string]Insight, len(ua.Insights)),
+		AnswersRevision: ua.AnswersRevision,
+	}
+	for id, qa := range ua.Answers {
+		history := make([]AnswerEvent, len(qa.History))
+		for i, ae := range qa.History {
+			history[i] = ae.clone()
+		}
+		c.Answers[id] = QuestionAnswers{LatestAnswer: qa.LatestAnswer.clone(), History: history}
+	}
+	for name, insight := range ua.Insights {
+		insight.InsightJson = slices.Clone(insight.InsightJson)
+		versions := make([]InsightVersion, len(insight.Versions))
+		for i, v := range insight.Versions {
+			versions[i] = v.clone()
+		}
+		insight.Versions = versions
+		c.Insights[name] = insight
+	}
+	return c
+}
+
+func (iv InsightVersion) clone() InsightVersion {
+	iv.InsightJson = slices.Clone(iv.InsightJson)
+	return iv
+}
+
+func (ae AnswerEvent) clone() AnswerEvent {
+	if ae.Value != nil {
+		v := *ae.Value
+		ae.Value = &v
+	}
+	return ae
+}
+
+var _ Store = (*MemoryStore)(nil)
+var _ Store = (*MongoStore)(nil)
@@ -0,0 +1,361 @@
+package db_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"user-db/db"
+	"user-db/shared"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := db.NewMemoryStore()
+
+	if _, err := store.GetUser("unknown"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Fatalf("GetUser() error = %v, want ErrUserNotFound", err)
+	}
+	if err := store.UpsertAnswer("unknown", 1, shared.SCALE, 5); !errors.Is(err, db.ErrUserNotFound) {
+		t.Fatalf("UpsertAnswer() error = %v, want ErrUserNotFound", err)
+	}
+
+	if err := store.NewUser("user"); err != nil {
+		t.Fatalf("NewUser() failed: %v", err)
+	}
+	for _, v := range []int{3, 7} {
+		if err := store.UpsertAnswer("user", 1, shared.SCALE, v); err != nil {
+			t.Fatalf("UpsertAnswer() failed: %v", err)
+		}
+	}
+	if err := store.UpsertInsight("user", "holistic", db.DONE, nil); err == nil {
+		t.Fatalf("UpsertInsight() without version succeeded unexpectedly")
+	}
+	for _, blob := range []string{`{"a":1}`, `{"a":2}`} {
+		if err := store.UpsertInsight("user", "holistic", db.GENERATING, nil); err != nil {
+			t.Fatalf("UpsertInsight() failed: %v", err)
+		}
+		if err := store.UpsertInsight("user", "holistic", db.DONE, &db.InsightVersion{InsightJson: []byte(blob)}); err != nil {
+			t.Fatalf("UpsertInsight() failed: %v", err)
+		}
+	}
+
+	if err := store.UpsertInsight("user", "Habits", db.FAILED, nil); err == nil {
+		t.Fatalf("UpsertInsight() with FAILED succeeded unexpectedly")
+	}
+	if err := store.FailInsight("user", "Habits", "timeout"); err != nil {
+		t.Fatalf("FailInsight() failed: %v", err)
+	}
+
+	ua, err := store.GetUser("user")
+	if err != nil {
+		t.Fatalf("GetUser() failed: %v", err)
+	}
+	if insight := ua.Insights["Habits"]; insight.Status != db.FAILED || insight.Error != "timeout" || !ua.NeedsInsight("Habits") {
+		t.Errorf("failed insight = %+v", insight)
+	}
+	if got := *ua.GetLatestAnswer(1).Value; got != 7 {
+		t.Errorf("GetLatestAnswer(1) = %d, want 7", got)
+	}
+	if got := len(ua.GetAnswerHistory(1)); got != 2 {
+		t.Errorf("len(GetAnswerHistory(1)) = %d, want 2", got)
+	}
+	if !ua.HasInsight("holistic") {
+		t.Errorf("HasInsight(holistic) = false, want true")
+	}
+	if got := string(ua.GetInsight("holistic")); got != `{"a":2}` {
+		t.Errorf("GetInsight(holistic) = %s, want latest version", got)
+	}
+	if v, ok := ua.GetInsightVersion("holistic", 1); !ok || v.Version != 1 || string(v.InsightJson) != `{"a":1}` {
+		t.Errorf("GetInsightVersion(holistic, 1) = %+v, %v", v, ok)
+	}
+
+	// modifying the returned copy must not change the store
+	*ua.Answers[1].LatestAnswer.Value = 1
+	delete(ua.Insights, "holistic")
+	ua, _ = store.GetUser("user")
+	if got := *ua.GetLatestAnswer(1).Value; got != 7 {
+		t.Errorf("stored answer changed through copy: got %d, want 7", got)
+	}
+	if !ua.HasInsight("holistic") {
+		t.Errorf("stored insight removed through copy")
+	}
+
+	if err := store.ResetUser("user"); err != nil {
+		t.Fatalf("ResetUser() failed: %v", err)
+	}
+	ua, _ = store.GetUser("user")
+	if len(ua.Answers) != 0 || len(ua.Insights) != 0 {
+		t.Errorf("ResetUser() left data: %v", ua)
+	}
+
+	if err := store.DeleteUser("user"); err != nil {
+		t.Fatalf("DeleteUser() failed: %v", err)
+	}
+	if _, err := store.GetUser("user"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("GetUser() after delete error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestMemoryStore_InsightVersionsCapped(t *testing.T) {
+	const STORED = db.MAX_INSIGHT_VERSIONS + 3
+	store := db.NewMemoryStore()
+	if err := store.NewUser("user"); err != nil {
+		t.Fatalf("NewUser() failed: %v", err)
+	}
+	for i := 1; i <= STORED; i++ {
+		blob := fmt.Sprintf(`{"a":%d}`, i)
+		if err := store.UpsertInsight("user", "holistic", db.DONE, &db.InsightVersion{InsightJson: []byte(blob)}); err != nil {
+			t.Fatalf("UpsertInsight() failed: %v", err)
+		}
+	}
+
+	ua, _ := store.GetUser("user")
+	versions := ua.GetInsightVersions("holistic")
+	if len(versions) != db.MAX_INSIGHT_VERSIONS {
+		t.Fatalf("len(GetInsightVersions()) = %d, want %d", len(versions), db.MAX_INSIGHT_VERSIONS)
+	}
+	// version numbers do not shift when old versions are dropped
+	if first := versions[0].Version; first != 4 {
+		t.Errorf("oldest kept version = %d, want 4", first)
+	}
+	if got := ua.Insights["holistic"].LatestVersion(); got != STORED {
+		t.Errorf("LatestVersion() = %d, want %d", got, STORED)
+	}
+	if _, ok := ua.GetInsightVersion("holistic", 3); ok {
+		t.Errorf("GetInsightVersion(holistic, 3) found a dropped version")
+	}
+	want := fmt.Sprintf(`{"a":%d}`, STORED)
+	if v, ok := ua.GetInsightVersion("holistic", STORED); !ok || string(v.InsightJson) != want {
+		t.Errorf("GetInsightVersion(holistic, %d) = %+v, %v, want %s", STORED, v, ok, want)
+	}
+}
+
+func TestMemoryStore_InsightLease(t *testing.T) {
+	store := db.NewMemoryStore()
+	if err := store.NewUser("user"); err != nil {
+		t.Fatalf("NewUser() failed: %v", err)
+	}
+	// a second attempt takes the insight over from the first
+	for _, lease := range []string{"job/1", "job/2"} {
+		if err := store.StartInsight("user", "Habits", lease); err != nil {
+			t.Fatalf("StartInsight() failed: %v", err)
+		}
+	}
+
+	version := db.InsightVersion{InsightJson: []byte(`{"a":1}`)}
+	if err := store.FinishInsight("user", "Habits", "job/1", version); !errors.Is(err, db.ErrLeaseLost) {
+		t.Errorf("FinishInsight() of first attempt error = %v, want ErrLeaseLost", err)
+	}
+	if err := store.FinishInsight("user", "Habits", "job/2", version); err != nil {
+		t.Fatalf("FinishInsight() failed: %v", err)
+	}
+	if err := store.FinishInsight("user", "Habits", "job/2", version); !errors.Is(err, db.ErrLeaseLost) {
+		t.Errorf("second FinishInsight() error = %v, want ErrLeaseLost", err)
+	}
+
+	ua, _ := store.GetUser("user")
+	if insight := ua.Insights["Habits"]; insight.Status != db.DONE || len(insight.Versions) != 1 {
+		t.Errorf("insight = %+v, want DONE with one version", insight)
+	}
+}
+
+func TestMemoryStore_ReplaceAnswers(t *testing.T) {
+	store := db.NewMemoryStore()
+	if err := store.ReplaceAnswers("unknown", 0, nil); !errors.Is(err, db.ErrUserNotFound) {
+		t.Fatalf("ReplaceAnswers() error = %v, want ErrUserNotFound", err)
+	}
+	if err := store.NewUser("user"); err != nil {
+		t.Fatalf("NewUser() failed: %v", err)
+	}
+	read, _ := store.GetUser("user")
+
+	// an answer submitted after the answers were read
+	if err := store.UpsertAnswer("user", 1, shared.SCALE, 4); err != nil {
+		t.Fatalf("UpsertAnswer() failed: %v", err)
+	}
+	value := 2
+	replacement := map[int]db.QuestionAnswers{2: {LatestAnswer: db.AnswerEvent{Kind: "SCALE", Value: &value}}}
+	if err := store.ReplaceAnswers("user", read.AnswersRevision, replacement); !errors.Is(err, db.ErrAnswersChanged) {
+		t.Fatalf("ReplaceAnswers() of outdated answers error = %v, want ErrAnswersChanged", err)
+	}
+	ua, _ := store.GetUser("user")
+	if ua.GetLatestAnswer(1) == nil {
+		t.Fatalf("ReplaceAnswers() of outdated answers dropped the submitted answer")
+	}
+
+	if err := store.ReplaceAnswers("user", ua.AnswersRevision, replacement); err != nil {
+		t.Fatalf("ReplaceAnswers() failed: %v", err)
+	}
+	replaced, _ := store.GetUser("user")
+	if replaced.GetLatestAnswer(2) == nil || replaced.AnswersRevision != ua.AnswersRevision+1 {
+		t.Errorf("replaced user = %+v, want answer 2 at the next revision", replaced)
+	}
+}
+
+func TestMemoryStore_LegacyAnswerHistory(t *testing.T) {
+	store := db.NewMemoryStore()
+	if err := store.NewUser("user"); err != nil {
+		t.Fatalf("NewUser() failed: %v", err)
+	}
+
+	// an answer stored before histories existed
+	value := 3
+	answeredAt := time.Now().Add(-2 * time.Hour)
+	legacy := map[int]db.QuestionAnswers{1: {LatestAnswer: db.AnswerEvent{Kind: "SCALE", Value: &value, UpdatedAt: answeredAt}}}
+	if err := store.ReplaceAnswers("user", 0, legacy); err != nil {
+		t.Fatalf("ReplaceAnswers() failed: %v", err)
+	}
+
+	ua, err := store.UpsertAnswers("user", []db.AnswerUpdate{{QuestionID: 1, Kind: shared.SCALE, Value: 7}})
+	if err != nil {
+		t.Fatalf("UpsertAnswers() failed: %v", err)
+	}
+	if got := len(ua.GetAnswerHistory(1)); got != 2 {
+		t.Errorf("len(GetAnswerHistory(1)) = %d, want the legacy answer and the new one", got)
+	}
+	if ae := ua.GetAnswerAsOf(1, time.Now().Add(-time.Hour)); ae == nil || *ae.Value != 3 {
+		t.Errorf("GetAnswerAsOf(1, an hour ago) = %+v, want the legacy answer", ae)
+	}
+}
+
+func TestMemoryStore_Questions(t *testing.T) {
+	store := db.NewMemoryStore()
+
+	if _, err := store.GetQuestionBank(); !errors.Is(err, db.ErrNoQuestions) {
+		t.Fatalf("GetQuestionBank() error = %v, want ErrNoQuestions", err)
+	}
+
+	first := []shared.Question{{ID: 1, Text: "first"}}
+	second := []shared.Question{{ID: 1, Text: "changed"}, {ID: 2, Text: "new"}}
+	for i, qs := range [][]shared.Question{first, second} {
+		bank, err := store.ImportQuestions(qs)
+		if err != nil {
+			t.Fatalf("ImportQuestions() failed: %v", err)
+		}
+		if bank.Revision != i+1 {
+			t.Errorf("ImportQuestions() revision = %d, want %d", bank.Revision, i+1)
+		}
+	}
+
+	q, err := store.GetQuestion(1)
+	if err != nil || q.Text != "changed" {
+		t.Errorf("GetQuestion(1) = %v, %v, want question of latest revision", q, err)
+	}
+	if _, err := store.GetQuestion(3); !errors.Is(err, db.ErrQuestionNotFound) {
+		t.Errorf("GetQuestion(3) error = %v, want ErrQuestionNotFound", err)
+	}
+
+	if err := store.DeleteQuestions(); err != nil {
+		t.Fatalf("DeleteQuestions() failed: %v", err)
+	}
+	if _, err := store.GetQuestionBank(); !errors.Is(err, db.ErrNoQuestions) {
+		t.Errorf("GetQuestionBank() after delete error = %v, want ErrNoQuestions", err)
+	}
+}
+
+func TestMemoryStore_Jobs(t *testing.T) {
+	store := db.NewMemoryStore()
+
+	if _, err := store.ClaimJob(time.Minute); !errors.Is(err, db.ErrNoJobDue) {
+		t.Fatalf("ClaimJob() error = %v, want ErrNoJobDue", err)
+	}
+
+	first, err := store.EnqueueJob("user", "Habits", time.Now())
+	if err != nil {
+		t.Fatalf("EnqueueJob() failed: %v", err)
+	}
+	if again, _ := store.EnqueueJob("user", "Habits", time.Now()); again.ID != first.ID {
+		t.Errorf("EnqueueJob() for active insight = %s, want existing job %s", again.ID, first.ID)
+	}
+	other, _ := store.EnqueueJob("other", "Habits", time.Now())
+
+	claimed, err := store.ClaimJob(time.Minute)
+	if err != nil || claimed.ID != first.ID || claimed.Status != db.JOB_RUNNING || claimed.Attempts != 1 {
+		t.Fatalf("ClaimJob() = %+v, %v, want first job running", claimed, err)
+	}
+
+	// a retried job is not due before its time
+	if err := store.RetryJob(first.ID, claimed.Attempts, time.Now().Add(time.Hour), "timeout"); err != nil {
+		t.Fatalf("RetryJob() failed: %v", err)
+	}
+	if claimed, _ := store.ClaimJob(time.Minute); claimed.ID != other.ID {
+		t.Errorf("ClaimJob() = %s, want %s", claimed.ID, other.ID)
+	}
+	if err := store.FinishJob(first.ID, claimed.Attempts, db.JOB_DONE, ""); !errors.Is(err, db.ErrLeaseLost) {
+		t.Errorf("FinishJob() of queued job error = %v, want ErrLeaseLost", err)
+	}
+	if err := store.FinishJob(other.ID, 1, db.JOB_DONE, ""); err != nil {
+		t.Fatalf("FinishJob() failed: %v", err)
+	}
+
+	// a running job is claimed again once its lease has expired
+	leased, _ := store.EnqueueJob("user", "Relationships", time.Now())
+	stale, err := store.ClaimJob(0)
+	if err != nil || stale.ID != leased.ID {
+		t.Fatalf("ClaimJob() = %+v, %v, want %s", stale, err, leased.ID)
+	}
+	claimed, err = store.ClaimJob(time.Minute)
+	if err != nil || claimed.ID != leased.ID || claimed.Attempts != 2 {
+		t.Fatalf("ClaimJob() after expired lease = %+v, %v", claimed, err)
+	}
+
+	// the worker that lost the lease can no longer update the job
+	if err := store.RetryJob(leased.ID, stale.Attempts, time.Now(), "timeout"); !errors.Is(err, db.ErrLeaseLost) {
+		t.Errorf("RetryJob() with lost lease error = %v, want ErrLeaseLost", err)
+	}
+	if err := store.FinishJob(leased.ID, stale.Attempts, db.JOB_FAILED, "timeout"); !errors.Is(err, db.ErrLeaseLost) {
+		t.Errorf("FinishJob() with lost lease error = %v, want ErrLeaseLost", err)
+	}
+	if err := store.FinishJob(leased.ID, claimed.Attempts, db.JOB_DONE, ""); err != nil {
+		t.Fatalf("FinishJob() failed: %v", err)
+	}
+	if job, _ := store.GetJob(leased.ID); job.Status != db.JOB_DONE || job.Error != "" {
+		t.Errorf("GetJob() = %+v, want done", job)
+	}
+
+	// a requeued job takes the same attempt again
+	requeued, _ := store.EnqueueJob("user", "Spirituality", time.Now())
+	if claimed, err = store.ClaimJob(time.Minute); err != nil || claimed.ID != requeued.ID {
+		t.Fatalf("ClaimJob() = %+v, %v, want %s", claimed, err, requeued.ID)
+	}
+	if err := store.RequeueJob(requeued.ID, claimed.Attempts, time.Now()); err != nil {
+		t.Fatalf("RequeueJob() failed: %v", err)
+	}
+	if err := store.RequeueJob(requeued.ID, claimed.Attempts, time.Now()); !errors.Is(err, db.ErrLeaseLost) {
+		t.Errorf("RequeueJob() of queued job error = %v, want ErrLeaseLost", err)
+	}
+	if claimed, _ = store.ClaimJob(time.Minute); claimed.ID != requeued.ID || claimed.Attempts != 1 {
+		t.Errorf("ClaimJob() after requeue = %+v, want attempt 1", claimed)
+	}
+
+	if next, _ := store.EnqueueJob("user", "Relationships", time.Now()); next.ID == leased.ID {
+		t.Errorf("EnqueueJob() after finished job returned the finished job")
+	}
+	if _, err := store.GetJob("unknown"); !errors.Is(err, db.ErrJobNotFound) {
+		t.Errorf("GetJob(unknown) error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestMemoryStore_Events(t *testing.T) {
+	store := db.NewMemoryStore()
+
+	if first, last, err := store.EventBounds(); err != nil || first != 0 || last != 0 {
+		t.Fatalf("EventBounds() of empty store = %d, %d, %v", first, last, err)
+	}
+	for _, user := range []string{"user", "other", "user"} {
+		if _, err := store.AppendEvent(db.Event{UserID: user, Name: "Habits", Kind: "done"}); err != nil {
+			t.Fatalf("AppendEvent() failed: %v", err)
+		}
+	}
+
+	if first, last, _ := store.EventBounds(); first != 1 || last != 3 {
+		t.Errorf("EventBounds() = %d, %d, want 1, 3", first, last)
+	}
+	events, err := store.EventsAfter("user", 1, 10)
+	if err != nil || len(events) != 1 || events[0].ID != 3 {
+		t.Errorf("EventsAfter(user) = %+v, %v, want event 3", events, err)
+	}
+	if events, _ := store.EventsAfter("", 0, 2); len(events) != 2 || events[1].ID != 2 {
+		t.Errorf("EventsAfter() with limit = %+v, want events 1 and 2", events)
+	}
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"errors"
+	"time"
+	"user-db/shared"
+)
+
+var ErrNoQuestions = errors.New("no questions imported")
+var ErrQuestionNotFound = errors.New("question not found")
+
+// QuestionBank is one imported revision of the questionnaire.
+type QuestionBank struct {
+	Revision   int               `json:"revision"`
+	ImportedAt time.Time         `json:"importedAt"`
+	Questions  []shared.Question `json:"questions"`
+}
+
+// QuestionStore persists revisions of the question bank. The revision with
+// the highest number is the active one.
+type QuestionStore interface {
+	// ImportQuestions stores the questions as a new revision and returns it.
+	ImportQuestions(questions []shared.Question) (QuestionBank, error)
+	GetQuestionBank() (QuestionBank, error)
+	GetQuestion(questionID int) (shared.Question, error)
+	// DeleteQuestions removes every revision.
+	DeleteQuestions() error
+}
+
+func findQuestion(bank QuestionBank, questionID int) (shared.Question, error) {
+	for _, q := range bank.Questions {
+		if q.ID == questionID {
+			return q, nil
+		}
+	}
+	return shared.Question{}, ErrQuestionNotFound
+}
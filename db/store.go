@@ -0,0 +1,80 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"user-db/shared"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrAnswersChanged = errors.New("answers changed")
+
+// UserStore persists the answers and insights of all users.
+type UserStore interface {
+	NewUser(userID string) error
+	GetUser(userID string) (UserAnswers, error)
+	ListUserIDs() ([]string, error)
+	DeleteUser(userID string) error
+	ResetUser(userID string) error
+	UpsertAnswer(userID string, questionID int, kind shared.AnswerKind, value int) error
+	// UpsertAnswers stores all answers in one atomic update and returns the
+	// user as committed. Either every answer is stored or none is.
+	UpsertAnswers(userID string, answers []AnswerUpdate) (UserAnswers, error)
+	// ReplaceAnswers overwrites all answers of a user, e.g. during migrations,
+	// if they are still at revision, see UserAnswers.AnswersRevision.
+	// Otherwise nothing is changed and ErrAnswersChanged is returned.
+	ReplaceAnswers(userID string, revision int, answers map[int]QuestionAnswers) error
+	// UpsertInsight sets the status of an insight. A version is required
+	// for DONE and is appended to the versions of the insight.
+	UpsertInsight(userID string, insightName string, status InsightStatus, version *InsightVersion) error
+	// StartInsight sets an insight to GENERATING for the job attempt lease,
+	// see Job.Lease. It replaces the lease of an earlier attempt.
+	StartInsight(userID string, insightName string, lease string) error
+	// FinishInsight appends a version to an insight and sets it to DONE, if
+	// lease is still the attempt that started it. Otherwise nothing is
+	// stored and ErrLeaseLost is returned.
+	FinishInsight(userID string, insightName string, lease string, version InsightVersion) error
+	// FailInsight sets an insight to FAILED with the reason of the failure.
+	// Earlier versions of the insight are kept.
+	FailInsight(userID string, insightName string, reason string) error
+	// MarkInsightStale flags an insight as outdated. Storing a new version
+	// with UpsertInsight clears the flag.
+	MarkInsightStale(userID string, insightName string) error
+}
+
+func checkInsightVersion(status InsightStatus, version *InsightVersion) error {
+	if status == DONE && version == nil {
+		return fmt.Errorf("insight with status %s needs a version", status)
+	}
+	if status == FAILED {
+		return fmt.Errorf("insight with status %s needs a reason, use FailInsight", status)
+	}
+	return nil
+}
+
+// Store holds users, the question bank, insight jobs and insight events.
+type Store interface {
+	UserStore
+	QuestionStore
+	JobStore
+	EventStore
+}
+
+// NewStore returns the store named by kind: "mongo" or "" connects to
+// MongoDB at uri, which is required; "memory" returns an in-memory store
+// that loses everything on restart, for local development.
+func NewStore(kind string, uri string) (Store, error) {
+	switch kind {
+	case "", "mongo":
+		if uri == "" {
+			return nil, errors.New("no MongoDB URI set")
+		}
+		return NewMongoStore(uri)
+	case "memory":
+		log.Printf("Using in-memory store, data is lost on restart")
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
@@ -0,0 +1,28 @@
+package db_test
+
+import (
+	"testing"
+	"user-db/db"
+)
+
+func TestNewStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		uri     string
+		wantErr bool
+	}{
+		{name: "memory", kind: "memory"},
+		{name: "mongo-without-uri", kind: "mongo", wantErr: true},
+		{name: "default-without-uri", kind: "", wantErr: true},
+		{name: "unknown", kind: "sqlite", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := db.NewStore(tt.kind, tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewStore(%q) error = %v, wantErr %v", tt.kind, err, tt.wantErr)
+			}
+		})
+	}
+}
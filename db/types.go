@@ -3,6 +3,7 @@ package db
 import (
 	"encoding/json"
 	"time"
+	"user-db/shared"
 )
 
 type UserAnswers struct {
@@ -10,11 +11,16 @@ type UserAnswers struct {
 	UserID   string                  `json:"_id"`
 	Answers  map[int]QuestionAnswers `json:"answers"`
 	Insights map[string]Insight      `json:"insights"`
+	// AnswersRevision counts the updates of Answers, so that ReplaceAnswers
+	// can tell whether they changed since they were read
+	AnswersRevision int `json:"answersRevision"`
 }
 
 type QuestionAnswers struct {
 	LatestAnswer AnswerEvent `json:"latestAnswer"`
-	// History []AnswerEvent `json:"history" json:"history"`
+	// History holds every submitted answer, oldest first. LatestAnswer is
+	// always the last entry.
+	History []AnswerEvent `json:"history"`
 }
 
 type AnswerEvent struct {
@@ -23,9 +29,51 @@ type AnswerEvent struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// AnswerUpdate is a single answer of a submission.
+type AnswerUpdate struct {
+	QuestionID int
+	Kind       shared.AnswerKind
+	Value      int
+}
+
 type Insight struct {
-	Status      InsightStatus   `json:"status"`
+	Status InsightStatus `json:"status"`
+	// Error is the reason of the last failed generation, set while FAILED
+	Error string `json:"error,omitempty"`
+	// Stale is set when the answers changed since the latest version was
+	// generated, until a new version is stored
+	Stale bool `json:"stale"`
+	// CreatedAt is the time the insight was first requested, UpdatedAt the
+	// time of the last status change
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Lease is the job attempt generating the insight, see StartInsight
+	Lease string `json:"-"`
+	// InsightJson is the content of the latest version
+	InsightJson json.RawMessage `json:"insightJson"`
+	// Versions holds the latest MAX_INSIGHT_VERSIONS generated versions,
+	// oldest first
+	Versions []InsightVersion `json:"versions"`
+	// VersionCount is the number of versions ever generated, so that version
+	// numbers stay the same when old versions are dropped. It is 0 for
+	// insights stored before versions were capped.
+	VersionCount int `json:"versionCount"`
+}
+
+// MAX_INSIGHT_VERSIONS bounds the versions kept per insight; older versions
+// are dropped when a new one is stored.
+const MAX_INSIGHT_VERSIONS = 20
+
+type InsightVersion struct {
+	Version     int             `json:"version" bson:"-"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	PromptID    string          `json:"promptId"`
+	Model       string          `json:"model"`
+	Input       string          `json:"input"`
 	InsightJson json.RawMessage `json:"insightJson"`
+	// Fingerprint identifies the answers the version was generated from,
+	// see UserAnswers.Fingerprint
+	Fingerprint string `json:"fingerprint"`
 }
 
 type InsightStatus string
@@ -33,4 +81,5 @@ type InsightStatus string
 const (
 	GENERATING InsightStatus = "GENERATING"
 	DONE       InsightStatus = "DONE"
+	FAILED     InsightStatus = "FAILED"
 )
@@ -2,9 +2,9 @@ package db
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"os"
 	"strconv"
 	"time"
 	"user-db/shared"
@@ -15,126 +15,555 @@ import (
 	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
 )
 
-var client *mongo.Client
 var DATABASE_NAME string = "goodforyou"
 var USERANSWERS string = "useranswers"
 var QUESTIONS string = "questions"
+var JOBS string = "jobs"
+var EVENTS string = "events"
+var COUNTERS string = "counters"
 
-func init() {
+type MongoStore struct {
+	client *mongo.Client
+}
+
+func NewMongoStore(uri string) (*MongoStore, error) {
 	// Use the SetServerAPIOptions() method to set the version of the Stable API on the client
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
 
-	uri := os.Getenv("MONGODB_URI")
-	log.Printf("Connecting to MongoDB at %s", uri)
+	log.Printf("Connecting to MongoDB")
 
 	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
 	// Create a new client and connect to the server
-	var err error
-
-	client, err = mongo.Connect(opts)
+	client, err := mongo.Connect(opts)
 	if err != nil {
-		log.Fatalf("Error connecting to MongoDB: %s", err)
+		return nil, fmt.Errorf("error connecting to MongoDB: %w", err)
 	}
 
 	// Send a ping to confirm a successful connection
 	if err := client.Ping(context.TODO(), readpref.Primary()); err != nil {
-		log.Fatalf("Error pinging MongoDB: %s", err)
+		return nil, fmt.Errorf("error pinging MongoDB: %w", err)
 	}
+
+	m := &MongoStore{client: client}
+
+	// at most one active job per user and insight, see EnqueueJob
+	_, err = m.jobs().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userid", Value: 1}, {Key: "insight", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"active": true}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating job index: %w", err)
+	}
+
+	// serves ClaimJob; finished jobs are left out of the index
+	_, err = m.jobs().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "runafter", Value: 1},
+			{Key: "leaseuntil", Value: 1},
+			{Key: "createdat", Value: 1},
+		},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"active": true}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating job index: %w", err)
+	}
+
+	// finished jobs are only kept for clients polling them
+	_, err = m.jobs().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "updatedat", Value: 1}},
+		Options: options.Index().
+			SetExpireAfterSeconds(int32(JOB_RETENTION.Seconds())).
+			SetPartialFilterExpression(bson.M{"active": false}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating job index: %w", err)
+	}
+
+	// events are only needed until every stream has caught up
+	_, err = m.events().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "createdat", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(EVENT_RETENTION.Seconds())),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating event index: %w", err)
+	}
+
+	return m, nil
+}
+
+func (m *MongoStore) userAnswers() *mongo.Collection {
+	return m.client.Database(DATABASE_NAME).Collection(USERANSWERS)
 }
 
-func NewUser(userid string) error {
-	collection := client.Database(DATABASE_NAME).Collection(USERANSWERS)
+func (m *MongoStore) NewUser(userid string) error {
 	var userAnswers UserAnswers
 	userAnswers.UserID = userid
 	userAnswers.Answers = make(map[int]QuestionAnswers)
 	userAnswers.Insights = make(map[string]Insight)
 
-	_, err := collection.InsertOne(context.TODO(), userAnswers)
+	_, err := m.userAnswers().InsertOne(context.TODO(), userAnswers)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetUser(userID string) (UserAnswers, error) {
-	collection := client.Database(DATABASE_NAME).Collection(USERANSWERS)
+func (m *MongoStore) GetUser(userID string) (UserAnswers, error) {
 	var result UserAnswers
 	filter := map[string]string{"userid": userID}
-	singleResult := collection.FindOne(context.TODO(), filter)
+	singleResult := m.userAnswers().FindOne(context.TODO(), filter)
 	err := singleResult.Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrUserNotFound
+	}
 
 	return result, err
 }
 
-func DeleteUser(userID string) {
-	collection := client.Database(DATABASE_NAME).Collection(USERANSWERS)
+func (m *MongoStore) ListUserIDs() ([]string, error) {
+	var userIDs []string
+	err := m.userAnswers().Distinct(context.TODO(), "userid", bson.M{}).Decode(&userIDs)
+	return userIDs, err
+}
+
+func (m *MongoStore) DeleteUser(userID string) error {
 	filter := map[string]string{"userid": userID}
-	singleResult := collection.FindOneAndDelete(context.TODO(), filter)
-	if singleResult.Err() != nil {
-		panic(singleResult.Err())
+	singleResult := m.userAnswers().FindOneAndDelete(context.TODO(), filter)
+	if err := singleResult.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		return err
 	}
 	log.Printf("deleted user: %v", userID)
+	return nil
 }
 
-func ResetUser(userID string) {
-	collection := client.Database(DATABASE_NAME).Collection(USERANSWERS)
+func (m *MongoStore) ResetUser(userID string) error {
 	filter := map[string]string{"userid": userID}
-	collection.FindOneAndDelete(context.TODO(), filter)
+	_, err := m.userAnswers().DeleteOne(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
 
-	NewUser(userID)
+	if err := m.NewUser(userID); err != nil {
+		return err
+	}
 
 	log.Printf("reset user: %v", userID)
+	return nil
 }
 
-func UpsertAnswer(userid string, questionID int, kind shared.AnswerKind, value int) error {
+func (m *MongoStore) UpsertAnswer(userid string, questionID int, kind shared.AnswerKind, value int) error {
+	_, err := m.UpsertAnswers(userid, []AnswerUpdate{{QuestionID: questionID, Kind: kind, Value: value}})
+	return err
+}
 
-	answer := AnswerEvent{
-		Kind:      kind.String(),
-		Value:     &value,
-		UpdatedAt: time.Now(),
+func (m *MongoStore) UpsertAnswers(userid string, answers []AnswerUpdate) (UserAnswers, error) {
+	if len(answers) == 0 {
+		return m.GetUser(userid)
 	}
 
-	latestPath := "answers." + strconv.Itoa(questionID) + ".latestAnswer"
+	now := time.Now()
+	set := bson.M{}
+	for _, a := range answers {
+		value := a.Value
+		answer := AnswerEvent{
+			Kind:      a.Kind.String(),
+			Value:     &value,
+			UpdatedAt: now,
+		}
+		// paths follow the default bson naming, the lowercased field names
+		answerPath := "answers." + strconv.Itoa(a.QuestionID)
+		history := "$" + answerPath + ".history"
+		// answers were once written to latestAnswer
+		latest := bson.M{"$ifNull": bson.A{"$" + answerPath + ".latestanswer", "$" + answerPath + ".latestAnswer"}}
+		// answers stored before histories existed only have a latest
+		// answer, which becomes the first history entry
+		seed := bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{history, bson.A{}}}}, 0}},
+			history,
+			bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": latest}, "object"}},
+				bson.A{latest},
+				bson.A{},
+			}},
+		}}
+		set[answerPath+".latestanswer"] = bson.M{"$literal": answer}
+		set[answerPath+".history"] = bson.M{"$concatArrays": bson.A{seed, bson.A{bson.M{"$literal": answer}}}}
+	}
 
-	coll := client.Database(DATABASE_NAME).Collection(USERANSWERS)
+	set["answersrevision"] = bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$answersrevision", 0}}, 1}}
 
 	filter := bson.M{"userid": userid}
+	// an update pipeline, so that the new history can be built from the
+	// stored one
+	update := bson.A{bson.M{"$set": set}}
+
+	// a single document update is atomic in MongoDB
+	var result UserAnswers
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := m.userAnswers().FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrUserNotFound
+	}
+	return result, err
+}
+
+func (m *MongoStore) ReplaceAnswers(userid string, revision int, answers map[int]QuestionAnswers) error {
+	// users stored before revisions were counted have none, like revision 0
+	current := any(revision)
+	if revision == 0 {
+		current = bson.M{"$in": bson.A{0, nil}}
+	}
+	filter := bson.M{"userid": userid, "answersrevision": current}
 	update := bson.M{
 		"$set": bson.M{
-			latestPath: answer,
-		}}
+			"answers": answers,
+		},
+		"$inc": bson.M{"answersrevision": 1},
+	}
 
-	_, err := coll.UpdateOne(context.TODO(), filter, update)
+	err := m.updateUser(filter, update)
+	if !errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+	// tell a changed revision from a deleted user
+	if _, err := m.GetUser(userid); err != nil {
+		return err
+	}
+	return ErrAnswersChanged
+}
 
-	return err
+func (m *MongoStore) UpsertInsight(userid string, insightsName string, status InsightStatus, version *InsightVersion) error {
+	if err := checkInsightVersion(status, version); err != nil {
+		return err
+	}
+
+	filter := bson.M{"userid": userid}
+	set := insightSet(insightsName, status, version)
+	return m.updateUser(filter, bson.A{bson.M{"$set": set}})
+}
+
+func (m *MongoStore) StartInsight(userid string, insightsName string, lease string) error {
+	filter := bson.M{"userid": userid}
+	set := insightSet(insightsName, GENERATING, nil)
+	set["insights."+insightsName+".lease"] = lease
+	return m.updateUser(filter, bson.A{bson.M{"$set": set}})
+}
+
+func (m *MongoStore) FinishInsight(userid string, insightsName string, lease string, version InsightVersion) error {
+	insightsPath := "insights." + insightsName
+
+	// the lease is checked in the same document update that stores the
+	// version, so an attempt that lost it cannot store a version
+	filter := bson.M{"userid": userid, insightsPath + ".lease": lease}
+	set := insightSet(insightsName, DONE, &version)
+	set[insightsPath+".lease"] = ""
+
+	result, err := m.userAnswers().UpdateOne(context.TODO(), filter, bson.A{bson.M{"$set": set}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
 }
 
-func UpsertInsight(userid string, insightsName, insightBlob string, status InsightStatus) error {
+// insightSet returns the $set stage of an update pipeline that sets the
+// status of an insight and appends version, if any. A pipeline lets the
+// version count and the kept versions be computed from the stored ones.
+func insightSet(insightsName string, status InsightStatus, version *InsightVersion) bson.M {
+	// paths follow the default bson naming, the lowercased field names
+	insightsPath := "insights." + insightsName
+
+	now := time.Now()
+	set := bson.M{
+		insightsPath + ".status":    status,
+		insightsPath + ".error":     "",
+		insightsPath + ".updatedat": now,
+		// sets createdat only if the insight has none
+		insightsPath + ".createdat": bson.M{"$ifNull": bson.A{"$" + insightsPath + ".createdat", now}},
+	}
+	if version != nil {
+		versions := bson.M{"$ifNull": bson.A{"$" + insightsPath + ".versions", bson.A{}}}
+		set[insightsPath+".insightjson"] = bson.M{"$literal": version.InsightJson}
+		set[insightsPath+".stale"] = false
+		// insights stored before versions were counted were never capped
+		set[insightsPath+".versioncount"] = bson.M{"$add": bson.A{
+			bson.M{"$max": bson.A{"$" + insightsPath + ".versioncount", bson.M{"$size": versions}}},
+			1,
+		}}
+		// keeps the latest MAX_INSIGHT_VERSIONS versions
+		set[insightsPath+".versions"] = bson.M{"$slice": bson.A{
+			bson.M{"$concatArrays": bson.A{versions, bson.A{bson.M{"$literal": version}}}},
+			-MAX_INSIGHT_VERSIONS,
+		}}
+	}
+	return set
+}
 
+func (m *MongoStore) FailInsight(userid string, insightsName string, reason string) error {
 	insightsPath := "insights." + insightsName
 
+	now := time.Now()
+	filter := bson.M{"userid": userid}
+	update := bson.M{
+		"$set": bson.M{
+			insightsPath + ".status":    FAILED,
+			insightsPath + ".error":     reason,
+			insightsPath + ".updatedat": now,
+		},
+		"$min": bson.M{insightsPath + ".createdat": now},
+	}
+
+	return m.updateUser(filter, update)
+}
+
+func (m *MongoStore) MarkInsightStale(userid string, insightsName string) error {
 	filter := bson.M{"userid": userid}
+	update := bson.M{
+		"$set": bson.M{
+			"insights." + insightsName + ".stale":     true,
+			"insights." + insightsName + ".updatedat": time.Now(),
+		}}
+
+	return m.updateUser(filter, update)
+}
 
-	var update bson.M
-	insight := Insight{
-		Status: status,
+func (m *MongoStore) updateUser(filter bson.M, update any) error {
+	result, err := m.userAnswers().UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return err
 	}
-	if status == GENERATING {
-		update = bson.M{
-			"$set": bson.M{
-				insightsPath: insight,
-			}}
-	} else {
-		insight.InsightJson = json.RawMessage(insightBlob)
-		update = bson.M{
-			"$set": bson.M{
-				insightsPath: insight,
-			}}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
 	}
+	return nil
+}
 
-	coll := client.Database(DATABASE_NAME).Collection(USERANSWERS)
+func (m *MongoStore) questions() *mongo.Collection {
+	return m.client.Database(DATABASE_NAME).Collection(QUESTIONS)
+}
 
-	_, err := coll.UpdateOne(context.TODO(), filter, update)
+func (m *MongoStore) ImportQuestions(questions []shared.Question) (QuestionBank, error) {
+	bank := QuestionBank{
+		Revision:   1,
+		ImportedAt: time.Now(),
+		Questions:  questions,
+	}
 
-	return err
+	latest, err := m.GetQuestionBank()
+	if err == nil {
+		bank.Revision = latest.Revision + 1
+	} else if !errors.Is(err, ErrNoQuestions) {
+		return QuestionBank{}, err
+	}
+
+	if _, err := m.questions().InsertOne(context.TODO(), bank); err != nil {
+		return QuestionBank{}, err
+	}
+	log.Printf("imported %d questions as revision %d", len(questions), bank.Revision)
+	return bank, nil
+}
+
+func (m *MongoStore) GetQuestionBank() (QuestionBank, error) {
+	var bank QuestionBank
+	opts := options.FindOne().SetSort(bson.D{{Key: "revision", Value: -1}})
+	err := m.questions().FindOne(context.TODO(), bson.M{}, opts).Decode(&bank)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrNoQuestions
+	}
+	return bank, err
+}
+
+func (m *MongoStore) GetQuestion(questionID int) (shared.Question, error) {
+	bank, err := m.GetQuestionBank()
+	if err != nil {
+		return shared.Question{}, err
+	}
+	return findQuestion(bank, questionID)
+}
+
+func (m *MongoStore) DeleteQuestions() error {
+	result, err := m.questions().DeleteMany(context.TODO(), bson.M{})
+	if err != nil {
+		return err
+	}
+	log.Printf("deleted %d question revisions", result.DeletedCount)
+	return nil
+}
+
+func (m *MongoStore) jobs() *mongo.Collection {
+	return m.client.Database(DATABASE_NAME).Collection(JOBS)
+}
+
+func (m *MongoStore) EnqueueJob(userID string, insight string, runAfter time.Time) (Job, error) {
+	job := newJob(userID, insight, time.Now(), runAfter)
+	var stored Job
+
+	// postpone a queued job
+	queued := bson.M{"userid": userID, "insight": insight, "active": true, "status": JOB_QUEUED}
+	postpone := bson.M{"$max": bson.M{"runafter": runAfter}}
+	err := m.jobs().FindOneAndUpdate(context.TODO(), queued, postpone, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&stored)
+	if err == nil {
+		return stored, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return Job{}, err
+	}
+
+	// insert the job unless an active one exists; the unique index makes
+	// concurrent upserts fail instead of creating a second active job
+	filter := bson.M{"userid": userID, "insight": insight, "active": true}
+	update := bson.M{"$setOnInsert": job}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err = m.jobs().FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		err = m.jobs().FindOne(context.TODO(), filter).Decode(&stored)
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	return stored, nil
+}
+
+func (m *MongoStore) GetJob(id string) (Job, error) {
+	var job Job
+	err := m.jobs().FindOne(context.TODO(), bson.M{"_id": id}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrJobNotFound
+	}
+	return job, err
+}
+
+func (m *MongoStore) ClaimJob(lease time.Duration) (Job, error) {
+	now := time.Now()
+	// active lets the query use the partial claim index
+	filter := bson.M{"active": true, "$or": bson.A{
+		bson.M{"status": JOB_QUEUED, "runafter": bson.M{"$lte": now}},
+		bson.M{"status": JOB_RUNNING, "leaseuntil": bson.M{"$lte": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     JOB_RUNNING,
+			"leaseuntil": now.Add(lease),
+			"updatedat":  now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdat", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job Job
+	err := m.jobs().FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrNoJobDue
+	}
+	return job, err
+}
+
+func (m *MongoStore) RetryJob(id string, attempt int, runAfter time.Time, reason string) error {
+	return m.updateJob(id, attempt, bson.M{
+		"status":   JOB_QUEUED,
+		"runafter": runAfter,
+		"error":    reason,
+	})
+}
+
+func (m *MongoStore) RequeueJob(id string, attempt int, runAfter time.Time) error {
+	return m.updateJob(id, attempt, bson.M{
+		"status":   JOB_QUEUED,
+		"runafter": runAfter,
+		// the next claim takes the same attempt again
+		"attempts": attempt - 1,
+	})
+}
+
+func (m *MongoStore) FinishJob(id string, attempt int, status JobStatus, reason string) error {
+	return m.updateJob(id, attempt, bson.M{
+		"status": status,
+		"error":  reason,
+		"active": false,
+	})
+}
+
+// updateJob updates a running job if attempt still holds its lease.
+func (m *MongoStore) updateJob(id string, attempt int, set bson.M) error {
+	set["updatedat"] = time.Now()
+	filter := bson.M{"_id": id, "attempts": attempt, "status": JOB_RUNNING}
+	result, err := m.jobs().UpdateOne(context.TODO(), filter, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (m *MongoStore) events() *mongo.Collection {
+	return m.client.Database(DATABASE_NAME).Collection(EVENTS)
+}
+
+func (m *MongoStore) AppendEvent(ev Event) (Event, error) {
+	// the counter hands out IDs in order across all instances
+	var counter struct {
+		Seq int64
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := m.client.Database(DATABASE_NAME).Collection(COUNTERS).
+		FindOneAndUpdate(context.TODO(), bson.M{"_id": EVENTS}, bson.M{"$inc": bson.M{"seq": 1}}, opts).
+		Decode(&counter)
+	if err != nil {
+		return Event{}, err
+	}
+
+	ev.ID = counter.Seq
+	ev.CreatedAt = time.Now()
+	if _, err := m.events().InsertOne(context.TODO(), ev); err != nil {
+		return Event{}, err
+	}
+	return ev, nil
+}
+
+func (m *MongoStore) EventsAfter(userID string, after int64, limit int) ([]Event, error) {
+	filter := bson.M{"_id": bson.M{"$gt": after}}
+	if userID != "" {
+		filter["userid"] = userID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := m.events().Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	if err := cursor.All(context.TODO(), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (m *MongoStore) EventBounds() (int64, int64, error) {
+	var bounds [2]int64
+	for i, order := range []int{1, -1} {
+		var ev Event
+		opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: order}}).SetProjection(bson.M{"_id": 1})
+		err := m.events().FindOne(context.TODO(), bson.M{}, opts).Decode(&ev)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, 0, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		bounds[i] = ev.ID
+	}
+	return bounds[0], bounds[1], nil
 }
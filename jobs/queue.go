@@ -0,0 +1,225 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"user-db/db"
+)
+
+// Handler runs the jobs of a Queue.
+type Handler interface {
+	// RunJob does the work of a job. A returned error is retried until
+	// the job runs out of attempts. ctx is done once the lease of the job
+	// has expired, after which another worker may claim it; RunJob should
+	// not store any result then.
+	RunJob(ctx context.Context, job db.Job) error
+	// JobFailed is called once a job has failed for good.
+	JobFailed(job db.Job, err error)
+	// JobRequeued is called when a superseded job is queued again.
+	JobRequeued(job db.Job)
+}
+
+// Superseded is returned by RunJob when the result of a job is outdated
+// before it could be stored. The job is queued again to run after Delay,
+// without using up an attempt.
+type Superseded struct {
+	Delay time.Duration
+	Cause error
+}
+
+func (e *Superseded) Error() string {
+	return "superseded: " + e.Cause.Error()
+}
+
+func (e *Superseded) Unwrap() error {
+	return e.Cause
+}
+
+// Permanent is returned by RunJob for failures that trying again would not
+// fix. The job fails right away, whatever attempts it has left.
+type Permanent struct {
+	Err error
+}
+
+func (e *Permanent) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Permanent) Unwrap() error {
+	return e.Err
+}
+
+type Options struct {
+	// Workers is the number of jobs run at the same time
+	Workers int
+	// Lease is how long a job may run before it is given up and claimed
+	// again, e.g. after the instance running it died
+	Lease time.Duration
+	// MaxAttempts bounds how often a job is run
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled for every further one
+	Backoff time.Duration
+	// PollInterval is how often the store is checked for due jobs
+	PollInterval time.Duration
+}
+
+var DefaultOptions = Options{
+	Workers:      4,
+	Lease:        5 * time.Minute,
+	MaxAttempts:  3,
+	Backoff:      10 * time.Second,
+	PollInterval: 5 * time.Second,
+}
+
+// Queue runs insight jobs persisted in a db.JobStore with a bounded pool of
+// workers. Any number of instances may run a Queue on the same store.
+type Queue struct {
+	store   db.JobStore
+	handler Handler
+	opts    Options
+	wake    chan struct{}
+}
+
+func NewQueue(store db.JobStore, handler Handler, opts Options) *Queue {
+	return &Queue{
+		store:   store,
+		handler: handler,
+		opts:    opts,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Enqueue queues a job for an insight of a user to run after delay, unless
+// one is already queued or running, and wakes a worker. A queued job is
+// postponed, so repeated calls debounce the job.
+func (q *Queue) Enqueue(userID, insight string, delay time.Duration) (db.Job, error) {
+	job, err := q.store.EnqueueJob(userID, insight, time.Now().Add(delay))
+	if err != nil {
+		return db.Job{}, err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Job returns a job by its id.
+func (q *Queue) Job(id string) (db.Job, error) {
+	return q.store.GetJob(id)
+}
+
+// Run starts the workers and blocks until ctx is done and every worker has
+// finished its current job. Jobs cancelled by ctx are queued again.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range q.opts.Workers {
+		wg.Go(func() { q.work(ctx) })
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	poll := time.NewTicker(q.opts.PollInterval)
+	defer poll.Stop()
+
+	// a stopped queue claims no more jobs, not even the ones it queued again
+	for ctx.Err() == nil {
+		job, err := q.store.ClaimJob(q.opts.Lease)
+		if err == nil {
+			q.run(ctx, job)
+			continue
+		}
+		if !errors.Is(err, db.ErrNoJobDue) {
+			log.Printf("Failed trying to claim job: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-poll.C:
+		}
+	}
+}
+
+func (q *Queue) run(ctx context.Context, job db.Job) {
+	// a job claimed again after its lease expired may be out of attempts
+	if job.Attempts > q.opts.MaxAttempts {
+		q.fail(job, errors.New("lease expired in last attempt"))
+		return
+	}
+
+	jobCtx, cancel := context.WithDeadline(ctx, job.LeaseUntil)
+	defer cancel()
+
+	err := q.handler.RunJob(jobCtx, job)
+	if err == nil {
+		if err := q.store.FinishJob(job.ID, job.Attempts, db.JOB_DONE, ""); err != nil {
+			log.Printf("Failed trying to finish job %s: %s", job.ID, err)
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		// the queue is stopping; the job runs again without using up an
+		// attempt, on this instance or another one
+		log.Printf("Job %s (%s for user %s) stopped, queueing it again", job.ID, job.Insight, job.UserID)
+		if err := q.store.RequeueJob(job.ID, job.Attempts, time.Now()); err != nil {
+			log.Printf("Failed trying to queue job %s again: %s", job.ID, err)
+		}
+		return
+	}
+
+	if errors.Is(err, db.ErrLeaseLost) {
+		// another worker claimed the job after its lease expired and reports
+		// its outcome
+		log.Printf("Job %s (%s for user %s) lost its lease in attempt %d", job.ID, job.Insight, job.UserID, job.Attempts)
+		return
+	}
+
+	var superseded *Superseded
+	if errors.As(err, &superseded) {
+		q.supersede(job, superseded)
+		return
+	}
+
+	var permanent *Permanent
+	if job.Attempts < q.opts.MaxAttempts && !errors.As(err, &permanent) {
+		backoff := q.opts.Backoff << (job.Attempts - 1)
+		log.Printf("Job %s (%s for user %s) failed in attempt %d of %d, retrying in %s: %s", job.ID, job.Insight, job.UserID, job.Attempts, q.opts.MaxAttempts, backoff, err)
+		if err := q.store.RetryJob(job.ID, job.Attempts, time.Now().Add(backoff), err.Error()); err != nil {
+			log.Printf("Failed trying to retry job %s: %s", job.ID, err)
+		}
+		return
+	}
+
+	q.fail(job, err)
+}
+
+// supersede queues a job again, giving back its attempt.
+func (q *Queue) supersede(job db.Job, superseded *Superseded) {
+	log.Printf("Job %s (%s for user %s) superseded, queueing it again in %s: %s", job.ID, job.Insight, job.UserID, superseded.Delay, superseded.Cause)
+	if err := q.store.RequeueJob(job.ID, job.Attempts, time.Now().Add(superseded.Delay)); err != nil {
+		log.Printf("Failed trying to queue job %s again: %s", job.ID, err)
+		return
+	}
+	q.handler.JobRequeued(job)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) fail(job db.Job, cause error) {
+	log.Printf("Job %s (%s for user %s) failed: %s", job.ID, job.Insight, job.UserID, cause)
+	if err := q.store.FinishJob(job.ID, job.Attempts, db.JOB_FAILED, cause.Error()); err != nil {
+		// another worker owns the job now and reports its outcome
+		log.Printf("Failed trying to finish job %s: %s", job.ID, err)
+		return
+	}
+	q.handler.JobFailed(job, cause)
+}
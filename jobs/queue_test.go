@@ -0,0 +1,295 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"user-db/db"
+	"user-db/jobs"
+)
+
+// recordingHandler fails the first failures runs of every insight, for good
+// if permanent is set.
+type recordingHandler struct {
+	mu        sync.Mutex
+	failures  int
+	permanent bool
+	runs      map[string]int
+	failed    chan db.Job
+	requeued  chan db.Job
+	done      chan db.Job
+}
+
+func newRecordingHandler(failures int) *recordingHandler {
+	return &recordingHandler{
+		failures: failures,
+		runs:     map[string]int{},
+		failed:   make(chan db.Job, 8),
+		requeued: make(chan db.Job, 8),
+		done:     make(chan db.Job, 8),
+	}
+}
+
+func (h *recordingHandler) RunJob(ctx context.Context, job db.Job) error {
+	h.mu.Lock()
+	h.runs[job.Insight]++
+	runs := h.runs[job.Insight]
+	h.mu.Unlock()
+
+	if runs <= h.failures && h.permanent {
+		return &jobs.Permanent{Err: errors.New("bad request")}
+	}
+	if runs <= h.failures {
+		return errors.New("model unavailable")
+	}
+	h.done <- job
+	return nil
+}
+
+func (h *recordingHandler) JobFailed(job db.Job, err error) {
+	h.failed <- job
+}
+
+func (h *recordingHandler) JobRequeued(job db.Job) {
+	h.requeued <- job
+}
+
+var testOptions = jobs.Options{
+	Workers:      2,
+	Lease:        time.Second,
+	MaxAttempts:  3,
+	Backoff:      time.Millisecond,
+	PollInterval: 10 * time.Millisecond,
+}
+
+func runQueue(t *testing.T, store db.JobStore, h jobs.Handler, opts jobs.Options) *jobs.Queue {
+	t.Helper()
+	q := jobs.NewQueue(store, h, opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return q
+}
+
+// waitForJob waits until the job has finished with the given status.
+func waitForJob(t *testing.T, store db.JobStore, id string, status db.JobStatus) db.Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		job, err := store.GetJob(id)
+		if err != nil {
+			t.Fatalf("GetJob() failed: %v", err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job = %+v, want %s", job, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		permanent    bool
+		wantStatus   db.JobStatus
+		wantAttempts int
+	}{
+		{name: "success", failures: 0, wantStatus: db.JOB_DONE, wantAttempts: 1},
+		{name: "retried", failures: 2, wantStatus: db.JOB_DONE, wantAttempts: 3},
+		{name: "failed", failures: 3, wantStatus: db.JOB_FAILED, wantAttempts: 3},
+		{name: "permanent", failures: 1, permanent: true, wantStatus: db.JOB_FAILED, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.NewMemoryStore()
+			h := newRecordingHandler(tt.failures)
+			h.permanent = tt.permanent
+			q := runQueue(t, store, h, testOptions)
+
+			job, err := q.Enqueue("user", "Habits", 0)
+			if err != nil {
+				t.Fatalf("Enqueue() failed: %v", err)
+			}
+
+			job = waitForJob(t, store, job.ID, tt.wantStatus)
+			if job.Attempts != tt.wantAttempts {
+				t.Errorf("job attempts = %d, want %d", job.Attempts, tt.wantAttempts)
+			}
+			if tt.wantStatus == db.JOB_FAILED {
+				select {
+				case failed := <-h.failed:
+					if failed.ID != job.ID {
+						t.Errorf("JobFailed() for %s, want %s", failed.ID, job.ID)
+					}
+				case <-time.After(time.Second):
+					t.Errorf("JobFailed() not called")
+				}
+			}
+		})
+	}
+}
+
+func TestQueue_ExpiredLease(t *testing.T) {
+	store := db.NewMemoryStore()
+
+	// a job claimed by an instance that died before finishing it
+	job, _ := store.EnqueueJob("user", "Habits", time.Now())
+	if _, err := store.ClaimJob(10 * time.Millisecond); err != nil {
+		t.Fatalf("ClaimJob() failed: %v", err)
+	}
+
+	h := newRecordingHandler(0)
+	runQueue(t, store, h, testOptions)
+
+	job = waitForJob(t, store, job.ID, db.JOB_DONE)
+	if job.Attempts != 2 {
+		t.Errorf("job attempts = %d, want 2", job.Attempts)
+	}
+}
+
+// slowHandler overruns the lease in its first run and fails it.
+type slowHandler struct {
+	overran chan struct{}
+}
+
+func (h *slowHandler) RunJob(ctx context.Context, job db.Job) error {
+	if job.Attempts > 1 {
+		return nil
+	}
+	<-ctx.Done()
+	time.Sleep(100 * time.Millisecond)
+	defer close(h.overran)
+	return errors.New("model unavailable")
+}
+
+func (h *slowHandler) JobFailed(job db.Job, err error) {
+	panic("JobFailed() called for job with lost lease")
+}
+
+func (h *slowHandler) JobRequeued(job db.Job) {}
+
+func TestQueue_LostLease(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := &slowHandler{overran: make(chan struct{})}
+	opts := testOptions
+	opts.Lease = 20 * time.Millisecond
+	opts.MaxAttempts = 2
+	q := runQueue(t, store, h, opts)
+
+	job, _ := q.Enqueue("user", "Habits", 0)
+	job = waitForJob(t, store, job.ID, db.JOB_DONE)
+
+	// the first worker reports its failure after the job was done by another
+	<-h.overran
+	time.Sleep(20 * time.Millisecond)
+	job, _ = store.GetJob(job.ID)
+	if job.Status != db.JOB_DONE || job.Attempts != 2 || job.Error != "" {
+		t.Errorf("job = %+v, want done in attempt 2", job)
+	}
+}
+
+// supersedingHandler supersedes the first run of every job.
+type supersedingHandler struct {
+	*recordingHandler
+}
+
+func (h supersedingHandler) RunJob(ctx context.Context, job db.Job) error {
+	h.mu.Lock()
+	h.runs[job.Insight]++
+	runs := h.runs[job.Insight]
+	h.mu.Unlock()
+
+	if runs == 1 {
+		return &jobs.Superseded{Delay: time.Millisecond, Cause: errors.New("answers changed")}
+	}
+	h.done <- job
+	return nil
+}
+
+func TestQueue_Superseded(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := supersedingHandler{newRecordingHandler(0)}
+	opts := testOptions
+	opts.MaxAttempts = 1
+	q := runQueue(t, store, h, opts)
+
+	job, _ := q.Enqueue("user", "Habits", 0)
+	select {
+	case requeued := <-h.requeued:
+		if requeued.ID != job.ID {
+			t.Errorf("JobRequeued() for %s, want %s", requeued.ID, job.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("JobRequeued() not called")
+	}
+
+	// the same job runs again, its superseded attempt is not counted
+	job = waitForJob(t, store, job.ID, db.JOB_DONE)
+	if job.Attempts != 1 {
+		t.Errorf("job attempts = %d, want 1", job.Attempts)
+	}
+	select {
+	case failed := <-h.failed:
+		t.Errorf("JobFailed() called for %+v", failed)
+	default:
+	}
+}
+
+// blockingHandler runs every job until the queue is stopped.
+type blockingHandler struct {
+	started chan struct{}
+}
+
+func (h blockingHandler) RunJob(ctx context.Context, job db.Job) error {
+	close(h.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (h blockingHandler) JobFailed(job db.Job, err error) {
+	panic("JobFailed() called for stopped job")
+}
+
+func (h blockingHandler) JobRequeued(job db.Job) {}
+
+func TestQueue_Stop(t *testing.T) {
+	store := db.NewMemoryStore()
+	h := blockingHandler{started: make(chan struct{})}
+	opts := testOptions
+	opts.MaxAttempts = 1
+	q := jobs.NewQueue(store, h, opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(stopped)
+	}()
+
+	job, _ := q.Enqueue("user", "Habits", 0)
+	<-h.started
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Run() did not return after cancel")
+	}
+
+	// the stopped job is queued again for the next instance
+	job, _ = store.GetJob(job.ID)
+	if job.Status != db.JOB_QUEUED || job.Attempts != 0 {
+		t.Errorf("job = %+v, want queued without attempts", job)
+	}
+}
@@ -0,0 +1,38 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/openai/openai-go"
+)
+
+// ErrInvalidOutput is returned when the model answers with something that
+// is not a valid insight. The answer follows the schema sent as structured
+// output, so asking again rarely helps.
+var ErrInvalidOutput = errors.New("invalid model output")
+
+// IsTransient reports whether a failed generation may succeed when tried
+// again. Generations are retried by the insight jobs, not by the generators.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrInvalidOutput) {
+		return false
+	}
+
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+			return true
+		}
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
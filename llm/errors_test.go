@@ -0,0 +1,36 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"user-db/llm"
+
+	"github.com/openai/openai-go"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "rate-limited", err: &openai.Error{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "server-error", err: &openai.Error{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "bad-request", err: &openai.Error{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "invalid-output", err: fmt.Errorf("%w: no Brace found", llm.ErrInvalidOutput), want: false},
+		{name: "cancelled", err: fmt.Errorf("request: %w", context.Canceled), want: false},
+		{name: "unknown", err: errors.New("bad request"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := llm.IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"user-db/scoring"
+	"user-db/shared"
+)
+
+const FAKE_MODEL = FAKE
+const FAKE_PROMPT_ID = FAKE
+
+// FakeGenerator builds insights from the scores alone, without calling a
+// model. The same scores always produce the same insight, which makes it
+// suitable for tests and for running the server offline.
+type FakeGenerator struct{}
+
+func NewFakeGenerator() *FakeGenerator {
+	return &FakeGenerator{}
+}
+
+func (g *FakeGenerator) HolisticInsight(ctx context.Context, scores scoring.Result) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+
+	dims := scores.SortedDimensions()
+	facets := scores.SortedFacets()
+
+	insight := HolisticDocument{
+		Title:      "Your holistic profile",
+		Summary:    fmt.Sprintf("Based on %d rated dimensions and %d rated facets.", len(dims), len(facets)),
+		Strengths:  []Strength{},
+		FocusAreas: []FocusArea{},
+	}
+
+	// the highest dimensions are strengths, the lowest facets are focus areas
+	for i := len(dims) - 1; i >= 0 && i >= len(dims)-3; i-- {
+		insight.Strengths = append(insight.Strengths, Strength{
+			Dimension: dims[i].Name,
+			Text:      fmt.Sprintf("%s is one of your strongest dimensions.", dims[i].String()),
+		})
+	}
+	for _, f := range facets[:min(3, len(facets))] {
+		insight.FocusAreas = append(insight.FocusAreas, FocusArea{
+			Dimension: dimensionOf(scores, f),
+			Facet:     f.Name,
+			Text:      fmt.Sprintf("%s is rated %.1f.", f.FullName(), f.Value),
+			Actions:   []string{"Reflect on " + f.Name + " this week."},
+		})
+	}
+
+	return fakeResponse(insight, holisticInput(scores))
+}
+
+func (g *FakeGenerator) DimensionInsight(ctx context.Context, dimension scoring.DimensionScore, prompt shared.PromptConfig) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+
+	insight := DimensionDocument{
+		Dimension:       dimension.Name,
+		Summary:         fmt.Sprintf("%s, %s confidence.", dimension.String(), dimension.Confidence),
+		Facets:          []FacetInsight{},
+		Recommendations: []Recommendation{},
+	}
+	for _, sd := range dimension.SubDimensions {
+		for _, f := range sd.Facets {
+			if !f.IsScored() {
+				continue
+			}
+			insight.Facets = append(insight.Facets, FacetInsight{
+				Name:   f.FullName(),
+				Rating: f.Value,
+				Text:   fmt.Sprintf("%s is rated %.1f.", f.FullName(), f.Value),
+			})
+		}
+		if sd.IsScored() {
+			insight.Recommendations = append(insight.Recommendations, Recommendation{
+				Title: sd.Name,
+				Text:  fmt.Sprintf("Keep an eye on %s.", sd.Name),
+			})
+		}
+	}
+
+	return fakeResponse(insight, dimensionInput(dimension, prompt))
+}
+
+// dimensionOf returns the name of the dimension a facet belongs to.
+func dimensionOf(scores scoring.Result, facet scoring.FacetScore) string {
+	for _, d := range scores.Dimensions {
+		for _, sd := range d.SubDimensions {
+			if sd.Name == facet.SubDimension {
+				return d.Name
+			}
+		}
+	}
+	return ""
+}
+
+func fakeResponse(insight any, input string) (Response, error) {
+	out, err := json.Marshal(insight)
+	if err != nil {
+		return Response{}, err
+	}
+	return Response{
+		Output:   string(out),
+		PromptID: FAKE_PROMPT_ID,
+		Model:    FAKE_MODEL,
+		Input:    input,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package llm_test
+
+import (
+	"context"
+	"testing"
+	"user-db/llm"
+	"user-db/scoring"
+	"user-db/shared"
+)
+
+func testScores() scoring.Result {
+	facet := func(sd, name string, value float64) scoring.FacetScore {
+		return scoring.FacetScore{Score: scoring.Score{Name: name, Value: value, Answered: 1, Total: 1}, SubDimension: sd}
+	}
+	return scoring.Result{Dimensions: []scoring.DimensionScore{
+		{
+			Score: scoring.Score{Name: "Habits", Value: 3, Answered: 2, Total: 2},
+			Rank:  1,
+			SubDimensions: []scoring.SubDimensionScore{{
+				Score:  scoring.Score{Name: "Sleep", Value: 3, Answered: 2, Total: 2},
+				Facets: []scoring.FacetScore{facet("Sleep", "alertness", 2), facet("Sleep", "duration", 4)},
+			}},
+		},
+		{
+			Score: scoring.Score{Name: "Spirituality", Value: 8, Answered: 1, Total: 1},
+			Rank:  2,
+			SubDimensions: []scoring.SubDimensionScore{{
+				Score:  scoring.Score{Name: "Meaning", Value: 8, Answered: 1, Total: 1},
+				Facets: []scoring.FacetScore{facet("Meaning", "purpose", 8)},
+			}},
+		},
+	}}
+}
+
+func TestFakeGenerator_HolisticInsight(t *testing.T) {
+	g := llm.NewFakeGenerator()
+
+	resp, err := g.HolisticInsight(context.Background(), testScores())
+	if err != nil {
+		t.Fatalf("HolisticInsight() failed: %v", err)
+	}
+	if resp.Model != llm.FAKE_MODEL || resp.Input == "" {
+		t.Errorf("HolisticInsight() = %+v", resp)
+	}
+
+	insight, err := llm.ParseHolistic([]byte(resp.Output))
+	if err != nil {
+		t.Fatalf("HolisticInsight() output is invalid: %v", err)
+	}
+	if len(insight.Strengths) != 2 || insight.Strengths[0].Dimension != "Spirituality" {
+		t.Errorf("HolisticInsight() strengths = %+v", insight.Strengths)
+	}
+	if len(insight.FocusAreas) != 3 || insight.FocusAreas[0].Facet != "alertness" || insight.FocusAreas[0].Dimension != "Habits" {
+		t.Errorf("HolisticInsight() focus areas = %+v", insight.FocusAreas)
+	}
+
+	again, _ := g.HolisticInsight(context.Background(), testScores())
+	if again != resp {
+		t.Errorf("HolisticInsight() is not deterministic")
+	}
+}
+
+func TestFakeGenerator_DimensionInsight(t *testing.T) {
+	g := llm.NewFakeGenerator()
+	habits, _ := testScores().Dimension("Habits")
+
+	resp, err := g.DimensionInsight(context.Background(), habits, shared.PromptConfig{Input: shared.RATINGS_INPUT})
+	if err != nil {
+		t.Fatalf("DimensionInsight() failed: %v", err)
+	}
+	if resp.Input != habits.RatingsToString() {
+		t.Errorf("DimensionInsight() input = %q, want the ratings", resp.Input)
+	}
+
+	insight, err := llm.ParseDimension([]byte(resp.Output))
+	if err != nil {
+		t.Fatalf("DimensionInsight() output is invalid: %v", err)
+	}
+	if insight.Dimension != "Habits" || len(insight.Facets) != 2 || insight.Facets[0].Name != "Sleep.alertness" {
+		t.Errorf("DimensionInsight() = %+v", insight)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.DimensionInsight(ctx, habits, shared.PromptConfig{}); err == nil {
+		t.Errorf("DimensionInsight() with cancelled context succeeded")
+	}
+}
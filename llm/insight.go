@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// HolisticDocument is the holistic insight as described by HolisticSchema.
+type HolisticDocument struct {
+	Title      string      `json:"title"`
+	Summary    string      `json:"summary"`
+	Strengths  []Strength  `json:"strengths"`
+	FocusAreas []FocusArea `json:"focusAreas"`
+}
+
+type Strength struct {
+	Dimension string `json:"dimension"`
+	Text      string `json:"text"`
+}
+
+type FocusArea struct {
+	Dimension string   `json:"dimension"`
+	Facet     string   `json:"facet"`
+	Text      string   `json:"text"`
+	Actions   []string `json:"actions"`
+}
+
+// DimensionDocument is a dimension insight as described by DimensionSchema.
+type DimensionDocument struct {
+	Dimension       string           `json:"dimension"`
+	Summary         string           `json:"summary"`
+	Facets          []FacetInsight   `json:"facets"`
+	Recommendations []Recommendation `json:"recommendations"`
+}
+
+type FacetInsight struct {
+	Name   string  `json:"name"`
+	Rating float64 `json:"rating"`
+	Text   string  `json:"text"`
+}
+
+type Recommendation struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// ParseHolistic validates data against HolisticSchema and decodes it.
+func ParseHolistic(data []byte) (HolisticDocument, error) {
+	var doc HolisticDocument
+	return doc, parseDocument(HolisticSchema, data, &doc)
+}
+
+// ParseDimension validates data against DimensionSchema and decodes it.
+func ParseDimension(data []byte) (DimensionDocument, error) {
+	var doc DimensionDocument
+	return doc, parseDocument(DimensionSchema, data, &doc)
+}
+
+func parseDocument(schema Schema, data []byte, doc any) error {
+	if err := schema.Validate(data); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidOutput, schema.Name, err)
+	}
+	return json.Unmarshal(data, doc)
+}
@@ -4,92 +4,80 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"os"
 	"strings"
+	"user-db/scoring"
 	"user-db/shared"
-
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/packages/param"
-	"github.com/openai/openai-go/responses"
 )
 
-var client openai.Client
-var ctx context.Context
-
-func init() {
-	ctx = context.Background()
-	client = openai.NewClient()
+const HOLISTIC_PROMPT_ID = "pmpt_68a854a4a3c48193ba6b74da1a8e866a0c7c540e5eb70354"
+const DIMENSION_PROMPT_ID = "pmpt_68b6a4fd9d048196b3acf60938dc10040d196830d567e556"
 
+// Response is the JSON produced by a prompt together with what produced it.
+type Response struct {
+	Output   string
+	PromptID string
+	Model    string
+	Input    string
 }
 
-func HolisticPrompt(sortedDimensions []shared.CatVal, sortedFacets []shared.CatVal) string {
-
-	params := responses.ResponseNewParams{
-		Prompt: responses.ResponsePromptParam{
-			ID: "pmpt_68a854a4a3c48193ba6b74da1a8e866a0c7c540e5eb70354",
-		},
-		Input: responses.ResponseNewParamsInputUnion{
-			OfString: param.Opt[string]{Value: "Response in JSON\ndimension ratings:\n" + catValToString(sortedDimensions) + "\n\nfacets:\n" + catValToString(sortedFacets)},
-		},
-	}
-
-	resp, err := client.Responses.New(ctx, params)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	sanitizedOutput, err := sanitizeAndExtractJSON(resp.OutputText())
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	log.Printf("Received Prompt for holistic")
-
-	return sanitizedOutput
+// InsightGenerator writes the holistic and dimension insights from scores.
+// The output is a HolisticDocument or DimensionDocument as JSON, checked with
+// ParseHolistic and ParseDimension.
+type InsightGenerator interface {
+	HolisticInsight(ctx context.Context, scores scoring.Result) (Response, error)
+	DimensionInsight(ctx context.Context, dimension scoring.DimensionScore, prompt shared.PromptConfig) (Response, error)
 }
 
-func DimensionPrompt(dimensionName string, dimensionRatings string) string {
-	var params responses.ResponseNewParams
-	if dimensionName == shared.HABITS {
-		params = responses.ResponseNewParams{
-			Prompt: responses.ResponsePromptParam{
-				ID: "pmpt_690216e9f38c8196a2f610b858403c7b08557d4b1801b4a2",
-			},
-			Input: responses.ResponseNewParamsInputUnion{
-				OfString: param.Opt[string]{Value: dimensionRatings},
-			},
+const OPENAI = "openai"
+const FAKE = "fake"
+
+// NewInsightGenerator returns the generator named by provider. Without a
+// provider the OpenAI generator is used if an OPENAI_API_KEY is set and the
+// fake generator otherwise, so that the server runs offline.
+func NewInsightGenerator(provider string) (InsightGenerator, error) {
+	switch provider {
+	case OPENAI:
+		if os.Getenv("OPENAI_API_KEY") == "" {
+			return nil, errors.New("insight generator openai needs an OPENAI_API_KEY")
 		}
-	} else {
-		params = responses.ResponseNewParams{
-			Prompt: responses.ResponsePromptParam{
-				ID: "pmpt_68b6a4fd9d048196b3acf60938dc10040d196830d567e556",
-			},
-			Input: responses.ResponseNewParamsInputUnion{
-				OfString: param.Opt[string]{Value: "Response in JSON, Focus on Dimension " + dimensionName + "\nRatings:\n" + dimensionRatings},
-			},
+		return NewOpenAIGenerator(), nil
+	case FAKE:
+		return NewFakeGenerator(), nil
+	case "":
+		if os.Getenv("OPENAI_API_KEY") == "" {
+			log.Printf("No OPENAI_API_KEY set, using fake insight generator")
+			return NewFakeGenerator(), nil
 		}
+		return NewOpenAIGenerator(), nil
+	default:
+		return nil, fmt.Errorf("unknown insight generator %q", provider)
 	}
+}
 
-	resp, err := client.Responses.New(ctx, params)
-	if err != nil {
-		log.Fatal(err)
-	}
+func holisticInput(scores scoring.Result) string {
+	return "Response in JSON\ndimension ratings:\n" + dimensionsToString(scores.SortedDimensions()) + "\n\nfacets:\n" + facetsToString(scores.SortedFacets())
+}
 
-	sanitizedOutput, err := sanitizeAndExtractJSON(resp.OutputText())
-	if err != nil {
-		// TODO try again here once or twice?
-		log.Fatal("json not valid", err)
+func dimensionInput(dimension scoring.DimensionScore, prompt shared.PromptConfig) string {
+	if prompt.Input == shared.RATINGS_INPUT {
+		return dimension.RatingsToString()
 	}
+	return "Response in JSON, Focus on Dimension " + dimension.Name + "\nRatings:\n" + dimension.RatingsToString()
+}
 
-	log.Printf("Received Prompt for %s", dimensionName)
-
-	return sanitizedOutput
-
+func dimensionsToString(sortedDimensions []scoring.DimensionScore) (result string) {
+	for _, d := range sortedDimensions {
+		result += d.String() + "\n"
+	}
+	return result
 }
 
-func catValToString(sortedCat []shared.CatVal) (result string) {
-	for _, cv := range sortedCat {
-		result += cv.ToString() + "\n"
+func facetsToString(sortedFacets []scoring.FacetScore) (result string) {
+	for _, f := range sortedFacets {
+		result += fmt.Sprintf("%s: %.1f\n", f.FullName(), f.Value)
 	}
 	return result
 }
@@ -110,6 +98,9 @@ func sanitizeAndExtractJSON(raw string) (string, error) {
 
 	// Find the last closing brace
 	endBrace := strings.LastIndex(raw, "}")
+	if endBrace < startBrace {
+		return "", errors.New("no closing Brace found in the string")
+	}
 
 	// Extract the potential JSON substring
 	potentialJSON := raw[startBrace : endBrace+1]
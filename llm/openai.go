@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"user-db/scoring"
+	"user-db/shared"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/responses"
+)
+
+// OpenAIGenerator generates insights with stored prompts of the OpenAI
+// Responses API. The client reads OPENAI_API_KEY from the environment.
+// Failed requests are not retried by the client; the insight jobs retry
+// them, see IsTransient.
+// DimensionPromptID is used for dimensions without a prompt of their own.
+type OpenAIGenerator struct {
+	client            openai.Client
+	HolisticPromptID  string
+	DimensionPromptID string
+}
+
+func NewOpenAIGenerator() *OpenAIGenerator {
+	return &OpenAIGenerator{
+		client:            openai.NewClient(option.WithMaxRetries(0)),
+		HolisticPromptID:  HOLISTIC_PROMPT_ID,
+		DimensionPromptID: DIMENSION_PROMPT_ID,
+	}
+}
+
+func (g *OpenAIGenerator) HolisticInsight(ctx context.Context, scores scoring.Result) (Response, error) {
+	resp, err := g.prompt(ctx, g.HolisticPromptID, holisticInput(scores), HolisticSchema)
+	if err != nil {
+		return Response{}, fmt.Errorf("holistic prompt: %w", err)
+	}
+
+	log.Printf("Received Prompt for holistic")
+
+	return resp, nil
+}
+
+func (g *OpenAIGenerator) DimensionInsight(ctx context.Context, dimension scoring.DimensionScore, prompt shared.PromptConfig) (Response, error) {
+	promptID := prompt.ID
+	if promptID == "" {
+		promptID = g.DimensionPromptID
+	}
+
+	resp, err := g.prompt(ctx, promptID, dimensionInput(dimension, prompt), DimensionSchema)
+	if err != nil {
+		return Response{}, fmt.Errorf("dimension prompt for %s: %w", dimension.Name, err)
+	}
+
+	log.Printf("Received Prompt for %s", dimension.Name)
+
+	return resp, nil
+}
+
+// prompt runs a stored prompt with schema as structured output. The answer
+// is validated by the caller, see ParseHolistic and ParseDimension.
+func (g *OpenAIGenerator) prompt(ctx context.Context, promptID, input string, schema Schema) (Response, error) {
+	params := responses.ResponseNewParams{
+		Prompt: responses.ResponsePromptParam{
+			ID: promptID,
+		},
+		Input: responses.ResponseNewParamsInputUnion{
+			OfString: param.Opt[string]{Value: input},
+		},
+		Text: responses.ResponseTextConfigParam{
+			Format: responses.ResponseFormatTextConfigUnionParam{
+				OfJSONSchema: &responses.ResponseFormatTextJSONSchemaConfigParam{
+					Name:   schema.Name,
+					Schema: schema.Definition,
+					Strict: openai.Bool(true),
+				},
+			},
+		},
+	}
+
+	resp, err := g.client.Responses.New(ctx, params)
+	if err != nil {
+		return Response{}, err
+	}
+
+	sanitizedOutput, err := sanitizeAndExtractJSON(resp.OutputText())
+	if err != nil {
+		return Response{}, fmt.Errorf("%w: %w", ErrInvalidOutput, err)
+	}
+
+	return Response{
+		Output:   sanitizedOutput,
+		PromptID: promptID,
+		Model:    resp.Model,
+		Input:    input,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package llm
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// Schema is the JSON Schema of an insight. It is sent to the model as
+// structured output and checked on every answer before it is stored.
+type Schema struct {
+	Name       string
+	Definition map[string]any
+}
+
+var HolisticSchema = mustLoadSchema("holistic")
+var DimensionSchema = mustLoadSchema("dimension")
+
+func mustLoadSchema(name string) Schema {
+	data, err := schemaFiles.ReadFile("schemas/" + name + ".json")
+	if err != nil {
+		panic(err)
+	}
+	var definition map[string]any
+	if err := json.Unmarshal(data, &definition); err != nil {
+		panic(fmt.Sprintf("schema %s: %s", name, err))
+	}
+	return Schema{Name: name + "_insight", Definition: definition}
+}
+
+// Validate checks a JSON document against the schema. Only the keywords used
+// by the insight schemas are supported: type, properties, required,
+// additionalProperties, items, enum, minimum and maximum.
+func (s Schema) Validate(data []byte) error {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return validateValue(s.Definition, doc, "$")
+}
+
+func validateValue(schema map[string]any, value any, path string) error {
+	if t, ok := schema["type"].(string); ok && !hasType(value, t) {
+		return fmt.Errorf("%s: expected %s", path, t)
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+	}
+
+	if n, ok := value.(float64); ok {
+		if minimum, ok := schema["minimum"].(float64); ok && n < minimum {
+			return fmt.Errorf("%s: %v is below the minimum %v", path, n, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && n > maximum {
+			return fmt.Errorf("%s: %v is above the maximum %v", path, n, maximum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := v[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing field %q", path, name)
+				}
+			}
+		}
+		// check fields in a fixed order so that errors are stable
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: unknown field %q", path, name)
+				}
+				continue
+			}
+			if err := validateValue(property, v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateValue(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasType(value any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package llm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"user-db/llm"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"summary": {"type": "string"},
+		"facets": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"rating": {"type": "number", "minimum": 0, "maximum": 10}
+				},
+				"required": ["name", "rating"],
+				"additionalProperties": false
+			}
+		}
+	},
+	"required": ["summary", "facets"],
+	"additionalProperties": false
+}`
+
+func TestSchema_Validate(t *testing.T) {
+	var definition map[string]any
+	if err := json.Unmarshal([]byte(testSchema), &definition); err != nil {
+		t.Fatal(err)
+	}
+	schema := llm.Schema{Name: "test", Definition: definition}
+
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			doc:  `{"summary":"s","facets":[{"name":"Sleep.alertness","rating":3.5}]}`,
+		},
+		{
+			name:    "missing-field",
+			doc:     `{"summary":"s"}`,
+			wantErr: true,
+		},
+		{
+			name:    "renamed-field",
+			doc:     `{"summary":"s","facets":[],"tips":[]}`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong-type",
+			doc:     `{"summary":"s","facets":[{"name":"Sleep.alertness","rating":"high"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "out-of-range",
+			doc:     `{"summary":"s","facets":[{"name":"Sleep.alertness","rating":11}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "not-an-object",
+			doc:     `[]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := schema.Validate([]byte(tt.doc)); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDimension(t *testing.T) {
+	doc, err := llm.ParseDimension([]byte(`{"dimension":"Habits","summary":"s","facets":[{"name":"Sleep.alertness","rating":2,"text":"t"}],"recommendations":[]}`))
+	if err != nil || doc.Dimension != "Habits" || len(doc.Facets) != 1 || doc.Facets[0].Rating != 2 {
+		t.Errorf("ParseDimension() = %+v, %v", doc, err)
+	}
+	// a renamed field breaks the frontend
+	if _, err := llm.ParseDimension([]byte(`{"dimension":"Habits","summary":"s","facets":[],"tips":[]}`)); !errors.Is(err, llm.ErrInvalidOutput) {
+		t.Errorf("ParseDimension() error = %v, want ErrInvalidOutput", err)
+	}
+}
+
+func TestParseHolistic(t *testing.T) {
+	if _, err := llm.ParseHolistic([]byte(`{"title":"t","summary":"s","strengths":[],"focusAreas":[{"dimension":"Habits","facet":"alertness","text":"t"}]}`)); !errors.Is(err, llm.ErrInvalidOutput) {
+		t.Errorf("ParseHolistic() without actions error = %v, want ErrInvalidOutput", err)
+	}
+}
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"user-db/api"
+	"user-db/db"
+	"user-db/jobs"
+	"user-db/llm"
+	"user-db/questions"
 	"user-db/shared"
 )
 
@@ -16,18 +25,81 @@ func main() {
 		log.Fatalf("Error getting config: %s", err)
 	}
 
-	s := api.Server{
-		Broker: api.NewBroker(1024),
+	store, err := db.NewStore(config.Store, os.Getenv("MONGODB_URI"))
+	if err != nil {
+		log.Fatalf("Error opening store: %s", err)
+	}
+
+	if err := questions.Load(config.Questions, store); err != nil {
+		log.Fatalf("Error loading questions: %s", err)
+	}
+
+	insights, err := llm.NewInsightGenerator(config.InsightGenerator)
+	if err != nil {
+		log.Fatalf("Error creating insight generator: %s", err)
+	}
+
+	broker, err := api.NewBroker(context.Background(), config.Broker, store)
+	if err != nil {
+		log.Fatalf("Error creating broker: %s", err)
+	}
+
+	s := &api.Server{
+		Broker:   broker,
+		Store:    store,
+		Insights: insights,
+		Debounce: 30 * time.Second,
 	}
+	s.Queue = jobs.NewQueue(store, s, jobs.DefaultOptions)
+	queueCtx, stopQueue := context.WithCancel(context.Background())
+	queueDone := make(chan struct{})
+	go func() {
+		s.Queue.Run(queueCtx)
+		close(queueDone)
+	}()
+
 	// Wrap handlers with CORS middleware and user middleware
 	http.Handle("/v1/user/reset", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.ResetUser)))
 	http.Handle("/v1/user/id", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetUserId)))
+	http.Handle("/v1/dimensions", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetDimensions)))
 	http.Handle("/v1/questions", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetQuestions)))
 	http.Handle("/v1/responses", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.SubmitResponses)))
+	http.Handle("/v1/scores", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetScores)))
+	http.Handle("/v1/progress", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetProgress)))
+	http.Handle("/v1/answers/history", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetAnswerHistory)))
 	http.Handle("/v1/insights/llm/generate/holistic", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GenerateHolistic)))
+	http.Handle("/v1/insights/holistic", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.QueueHolistic)))
+	http.Handle(api.JOBS_PATH+"{id}", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetInsightJob)))
+	http.Handle("/v1/insights", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetInsights)))
+	http.Handle("/v1/insights/versions", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetInsightVersions)))
+	http.Handle("/v1/insights/diff", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetInsightDiff)))
 	http.Handle("/v1/insights/llm", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.GetInsightsLLM)))
 	http.Handle("/v1/insights/stream", api.WithCORS(config.CorsOrigins)(http.HandlerFunc(s.InsightsStream)))
 
+	srv := &http.Server{Addr: ":8080"}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		// ending the streams first lets Shutdown wait for the other requests
+		broker.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down: %s", err)
+		}
+
+		// running jobs are cancelled and queued again for the next instance
+		stopQueue()
+		<-queueDone
+	}()
+
 	log.Println("Server running")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdown
 }
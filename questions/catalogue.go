@@ -0,0 +1,99 @@
+package questions
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// CatalogueDimension describes a dimension of the question bank without its
+// questions. Label is the name to display.
+type CatalogueDimension struct {
+	Name          string                  `json:"name"`
+	Slug          string                  `json:"slug"`
+	Label         string                  `json:"label"`
+	Description   string                  `json:"description"`
+	Rank          int                     `json:"rank"`
+	Insights      bool                    `json:"insights"`
+	QuestionCount int                     `json:"questionCount"`
+	SubDimensions []CatalogueSubDimension `json:"subDimensions"`
+}
+
+type CatalogueSubDimension struct {
+	Name          string           `json:"name"`
+	Slug          string           `json:"slug"`
+	Label         string           `json:"label"`
+	Rank          int              `json:"rank"`
+	QuestionCount int              `json:"questionCount"`
+	Facets        []CatalogueFacet `json:"facets"`
+}
+
+type CatalogueFacet struct {
+	Name          string `json:"name"`
+	Slug          string `json:"slug"`
+	Label         string `json:"label"`
+	QuestionCount int    `json:"questionCount"`
+}
+
+// GetCatalogue returns the hierarchy of the loaded question bank, dimensions
+// ordered by rank.
+func GetCatalogue() []CatalogueDimension {
+	questionCounts := make(map[string]int)
+	for _, q := range questions {
+		questionCounts[q.Dimension]++
+	}
+
+	catalogue := []CatalogueDimension{}
+	for name, dimension := range dimensions {
+		config, ok := dimensionConfigs[name]
+		if !ok {
+			config.Label = name
+		}
+		catDim := CatalogueDimension{
+			Name:          name,
+			Slug:          dimension.Slug,
+			Label:         config.Label,
+			Description:   config.Description,
+			Rank:          dimension.Rank,
+			Insights:      config.Insights,
+			QuestionCount: questionCounts[name],
+			SubDimensions: []CatalogueSubDimension{},
+		}
+		for subDimName, subDim := range dimension.SubDimensions {
+			catSubDim := CatalogueSubDimension{
+				Name:   subDimName,
+				Slug:   subDim.Slug,
+				Label:  subDimName,
+				Rank:   subDim.Rank,
+				Facets: []CatalogueFacet{},
+			}
+			for facetName, facet := range subDim.Facets {
+				catSubDim.QuestionCount += len(facet.Questions)
+				catSubDim.Facets = append(catSubDim.Facets, CatalogueFacet{
+					Name:          facetName,
+					Slug:          facet.Slug,
+					Label:         facetName,
+					QuestionCount: len(facet.Questions),
+				})
+			}
+			// general questions of the sub-dimension
+			for _, q := range dimension.GeneralQuestions {
+				if q.SubDimension == subDimName {
+					catSubDim.QuestionCount++
+				}
+			}
+			slices.SortFunc(catSubDim.Facets, func(a, b CatalogueFacet) int {
+				return strings.Compare(a.Name, b.Name)
+			})
+			catDim.SubDimensions = append(catDim.SubDimensions, catSubDim)
+		}
+		slices.SortFunc(catDim.SubDimensions, func(a, b CatalogueSubDimension) int {
+			return a.Rank - b.Rank
+		})
+		catalogue = append(catalogue, catDim)
+	}
+	slices.SortFunc(catalogue, func(a, b CatalogueDimension) int {
+		return cmp.Or(a.Rank-b.Rank, strings.Compare(a.Name, b.Name))
+	})
+	return catalogue
+}
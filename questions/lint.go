@@ -0,0 +1,143 @@
+package questions
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"user-db/shared"
+)
+
+// number of columns in questions.csv, including the comment column
+const csvColumns = 9
+
+// Issue is a problem found in questions.csv. Warnings are content decisions
+// left open; they do not stop the file from loading.
+type Issue struct {
+	Line    int
+	Message string
+	Warning bool
+}
+
+func (i Issue) String() string {
+	if i.Warning {
+		return fmt.Sprintf("line %d: warning: %s", i.Line, i.Message)
+	}
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// Lint checks a questions.csv and returns every issue it finds, in line order.
+func Lint(data []byte) []Issue {
+	var issues []Issue
+	report := func(line int, format string, args ...any) {
+		issues = append(issues, Issue{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	// report wrong column counts as issues instead of failing
+	reader.FieldsPerRecord = -1
+
+	ids := make(map[int]int)
+	texts := make(map[string]int)
+	// names by slug, per level of the hierarchy
+	slugs := make(map[string]string)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				report(parseErr.Line, "%s", parseErr.Err)
+			} else {
+				report(0, "%s", err)
+			}
+			break
+		}
+		line, _ := reader.FieldPos(0)
+
+		if len(row) != csvColumns {
+			report(line, "expected %d columns, found %d", csvColumns, len(row))
+			continue
+		}
+
+		id, err := strconv.Atoi(row[colID])
+		if err != nil || id < 1 {
+			report(line, "invalid question id %q", row[colID])
+		} else if other, ok := ids[id]; ok {
+			report(line, "question id %d already used on line %d", id, other)
+		} else {
+			ids[id] = line
+		}
+
+		if _, ok := dimensionConfigs[row[colDimension]]; !ok {
+			report(line, "dimension %q missing in dimensions.json", row[colDimension])
+		}
+
+		for _, col := range []struct {
+			index int
+			name  string
+		}{
+			{colDimension, "dimension"},
+			{colSubDimension, "sub-dimension"},
+			{colFacet, "facet"},
+			{colText, "question text"},
+			{colMinLabel, "min label"},
+			{colMaxLabel, "max label"},
+		} {
+			value := row[col.index]
+			if value == "" {
+				report(line, "empty %s", col.name)
+			} else if strings.TrimSpace(value) != value {
+				report(line, "leading or trailing whitespace in %s %q", col.name, value)
+			}
+		}
+
+		if text := row[colText]; text != "" {
+			if other, ok := texts[text]; ok {
+				report(line, "question text already used on line %d", other)
+			} else {
+				texts[text] = line
+			}
+		}
+
+		for _, level := range []struct {
+			scope string
+			name  string
+		}{
+			{"dimension", row[colDimension]},
+			{"sub-dimension of " + row[colDimension], row[colSubDimension]},
+			{"facet of " + row[colDimension] + "." + row[colSubDimension], row[colFacet]},
+		} {
+			key := level.scope + "|" + shared.Slugify(level.name)
+			if other, ok := slugs[key]; ok && other != level.name {
+				report(line, "%s %q has the same slug as %q", level.scope, level.name, other)
+			} else {
+				slugs[key] = level.name
+			}
+		}
+
+		if row[colPolicy] == "" {
+			issues = append(issues, Issue{Line: line, Message: "no answer policy, DONTKNOW is not allowed until one is set", Warning: true})
+		} else if !slices.Contains(shared.AnswerPolicies, shared.AnswerPolicy(row[colPolicy])) {
+			report(line, "invalid answer policy %q, expected one of %q", row[colPolicy], shared.AnswerPolicies)
+		}
+	}
+	return issues
+}
+
+// lintError combines all issues but warnings into one error, nil if there
+// are none.
+func lintError(issues []Issue) error {
+	var errs []error
+	for _, issue := range issues {
+		if !issue.Warning {
+			errs = append(errs, errors.New(issue.String()))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,48 @@
+package questions
+
+import (
+	"encoding/json"
+	"fmt"
+	"user-db/shared"
+)
+
+// rank of dimensions missing in the manifest
+const defaultRank = 100
+
+type manifest struct {
+	Dimensions []shared.DimensionConfig `json:"dimensions"`
+}
+
+// loadManifest reads dimensions.json and returns the configs by dimension name.
+func loadManifest(data []byte) (map[string]shared.DimensionConfig, error) {
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid dimension manifest: %w", err)
+	}
+
+	configs := make(map[string]shared.DimensionConfig, len(m.Dimensions))
+	ranks := make(map[int]string, len(m.Dimensions))
+	for _, config := range m.Dimensions {
+		if config.Name == "" {
+			return nil, fmt.Errorf("dimension manifest: dimension without name")
+		}
+		if _, ok := configs[config.Name]; ok {
+			return nil, fmt.Errorf("dimension manifest: %s listed twice", config.Name)
+		}
+		if other, ok := ranks[config.Rank]; ok {
+			return nil, fmt.Errorf("dimension manifest: %s has the same rank as %s", config.Name, other)
+		}
+		if config.Label == "" {
+			config.Label = config.Name
+		}
+		configs[config.Name] = config
+		ranks[config.Rank] = config.Name
+	}
+	return configs, nil
+}
+
+// GetDimensionConfig returns the manifest entry of a dimension.
+func GetDimensionConfig(dimensionName string) (shared.DimensionConfig, bool) {
+	config, ok := dimensionConfigs[dimensionName]
+	return config, ok
+}
@@ -4,11 +4,15 @@ import (
 	_ "embed"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"log"
 	"maps"
+	"slices"
+	"strconv"
 	"strings"
 
 	"user-db/db"
+	"user-db/scoring"
 	"user-db/shared"
 )
 
@@ -21,68 +25,236 @@ var dimensions map[string]shared.Dimension
 var dimensionQuestions []shared.Question
 var questions map[int]shared.Question
 
-var dimensionOrder map[string]int = map[string]int{
-	"Habits":               1,
-	"Physical Health":      2,
-	"Mental Health":        3,
-	"Social Relationships": 4,
-	"Character & Virtue":   5,
-	"Meaning & Purpose":    6,
-	"Spirituality":         7,
-	"Material Stability":   8,
-}
+// Embed the dimension manifest
+//
+//go:embed dimensions.json
+var dimensionsJSON []byte
+
+var dimensionConfigs map[string]shared.DimensionConfig
+
+// revision of the loaded question bank, 0 is the embedded questions.csv
+var revision int
+
+// embeddedErr is the error of loading the embedded files, returned by Load.
+// Tests check the files, so that a broken edit fails CI.
+var embeddedErr error
 
 func init() {
-	err := loadQuestionsCSV()
+	embeddedErr = loadEmbedded()
+}
+
+// loadEmbedded loads the embedded dimension manifest and questions.csv.
+func loadEmbedded() error {
+	configs, err := loadManifest(dimensionsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to load dimensions: %w", err)
+	}
+	dimensionConfigs = configs
+
+	qs, err := loadQuestionsCSV(questionsCSV)
 	if err != nil {
-		log.Fatalf("Failed to load questions: %v", err)
+		return fmt.Errorf("failed to load questions: %w", err)
 	}
+	setQuestions(qs, 0)
+	return nil
 }
 
-func loadQuestionsCSV() error {
+// ParseCSV reads a questionnaire in the format of questions.csv.
+func ParseCSV(data []byte) ([]shared.Question, error) {
+	return loadQuestionsCSV(data)
+}
 
-	// Use the embedded questionsCSV data
-	reader := csv.NewReader(strings.NewReader(string(questionsCSV)))
-	rows, err := reader.ReadAll()
+// EmbeddedCSV returns the questions.csv the binary was built with.
+func EmbeddedCSV() []byte {
+	return questionsCSV
+}
+
+// Load loads the questions from source: "store" or "" loads the active
+// revision of store, see LoadFromStore; "embedded" uses the questions.csv the
+// binary was built with, whatever the store holds. It fails if the embedded
+// files are invalid.
+func Load(source string, store db.QuestionStore) error {
+	if embeddedErr != nil {
+		return embeddedErr
+	}
+	switch source {
+	case "", "store":
+		return LoadFromStore(store)
+	case "embedded":
+		qs, err := loadQuestionsCSV(questionsCSV)
+		if err != nil {
+			return err
+		}
+		setQuestions(qs, 0)
+		log.Printf("Loaded %d embedded questions", len(qs))
+		return nil
+	default:
+		return fmt.Errorf("unknown question source %q", source)
+	}
+}
+
+// LoadFromStore replaces the loaded questions with the active revision of the
+// store. The embedded questions stay in use if the store has none. A warning
+// is logged if the active revision differs from the embedded questions.csv,
+// whose changes only take effect once it is imported.
+func LoadFromStore(store db.QuestionStore) error {
+	bank, err := store.GetQuestionBank()
+	if errors.Is(err, db.ErrNoQuestions) {
+		log.Printf("No questions in store, using embedded questions")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	setQuestions(bank.Questions, bank.Revision)
+	log.Printf("Loaded %d questions of revision %d", len(bank.Questions), bank.Revision)
 
-	questions = make(map[int]shared.Question)
-	dimensions = make(map[string]shared.Dimension)
+	if embedded, err := loadQuestionsCSV(questionsCSV); err == nil && !sameQuestions(embedded, bank.Questions) {
+		log.Printf("WARNING: revision %d differs from the embedded questions.csv, run import-questions to use it", bank.Revision)
+	}
+	return nil
+}
 
-	for i, row := range rows {
+// sameQuestions reports whether a and b hold the same questions, in any order.
+func sameQuestions(a, b []shared.Question) bool {
+	byID := func(x, y shared.Question) int { return x.ID - y.ID }
+	return slices.Equal(slices.SortedFunc(slices.Values(a), byID), slices.SortedFunc(slices.Values(b), byID))
+}
+
+// Revision returns the revision of the loaded questions, 0 for the embedded ones.
+func Revision() int {
+	return revision
+}
 
+// columns of questions.csv
+const (
+	colID = iota
+	colDimension
+	colSubDimension
+	colFacet
+	colText
+	colMinLabel
+	colMaxLabel
+	colPolicy
+)
+
+// loadQuestionsCSV reads the questions and their IDs from the id column.
+// The file must pass Lint; IDs must never change once users have answered them.
+func loadQuestionsCSV(data []byte) ([]shared.Question, error) {
+
+	if err := lintError(Lint(data)); err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var qs []shared.Question
+	for _, row := range rows {
+		id, _ := strconv.Atoi(row[colID])
+		question, _ := parseQuestion(id, row[colDimension:])
+		qs = append(qs, question)
+	}
+	return qs, nil
+}
+
+// ParseLegacyCSV reads a questions.csv from before the id column was added.
+// Question IDs are the row numbers, as they were used back then.
+func ParseLegacyCSV(data []byte) ([]shared.Question, error) {
+
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var qs []shared.Question
+	for i, row := range rows {
 		// start with 1 to align with the google sheet
 		questionNumber := i + 1
-		// TODO validate more
-		if row[3] == "" {
-			return errors.New("question text cannot be empty")
+		question, err := parseQuestion(questionNumber, row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", questionNumber, err)
 		}
+		qs = append(qs, question)
+	}
+	return qs, nil
+}
 
-		question := shared.Question{
-			ID:           questionNumber,
-			Dimension:    row[0],
-			SubDimension: row[1],
-			Facet:        row[2],
-			Text:         row[3],
-			MinLabel:     row[4],
-			MaxLabel:     row[5],
+// parseQuestion reads the columns after the id column.
+func parseQuestion(id int, row []string) (shared.Question, error) {
+	if len(row) < colPolicy {
+		return shared.Question{}, fmt.Errorf("expected at least %d columns, found %d", colPolicy, len(row))
+	}
+	if row[colText-1] == "" {
+		return shared.Question{}, errors.New("question text cannot be empty")
+	}
+
+	return shared.Question{
+		ID:           id,
+		Dimension:    row[colDimension-1],
+		SubDimension: row[colSubDimension-1],
+		Facet:        row[colFacet-1],
+		Text:         row[colText-1],
+		MinLabel:     row[colMinLabel-1],
+		MaxLabel:     row[colMaxLabel-1],
+		Policy:       shared.AnswerPolicy(row[colPolicy-1]),
+		ScaleMin:     shared.DefaultScaleMin,
+		ScaleMax:     shared.DefaultScaleMax,
+	}, nil
+}
+
+// MigrationMapping maps the IDs of old questions to the IDs of the same
+// questions in current, matched by dimension and text. Old questions without
+// a match are returned separately.
+func MigrationMapping(old, current []shared.Question) (mapping map[int]int, unmatched []shared.Question) {
+	byText := make(map[string]int, len(current))
+	for _, q := range current {
+		byText[q.Dimension+"|"+q.Text] = q.ID
+	}
+
+	mapping = make(map[int]int, len(old))
+	for _, q := range old {
+		id, ok := byText[q.Dimension+"|"+q.Text]
+		if !ok {
+			unmatched = append(unmatched, q)
+			continue
 		}
-		questions[questionNumber] = question
+		mapping[q.ID] = id
+	}
+	return mapping, unmatched
+}
+
+func setQuestions(qs []shared.Question, rev int) {
+
+	questions = make(map[int]shared.Question)
+	dimensions = make(map[string]shared.Dimension)
+	dimensionQuestions = nil
+	revision = rev
+
+	for _, question := range qs {
+		config, ok := dimensionConfigs[question.Dimension]
+		if ok && !config.Enabled {
+			continue
+		}
+		questions[question.ID] = question
 
 		// init dimensions
 		dimension, ok := dimensions[question.Dimension]
 		if !ok {
-			rank, ok := dimensionOrder[question.Dimension]
-			if !ok {
-				rank = 100
+			rank := defaultRank
+			if config, ok := dimensionConfigs[question.Dimension]; ok {
+				rank = config.Rank
 			}
 
 			dimension = shared.Dimension{
 				SubDimensions:    make(map[string]shared.SubDimension),
 				GeneralQuestions: []shared.Question{},
 				Rank:             rank,
+				Slug:             shared.Slugify(question.Dimension),
 			}
 		}
 
@@ -95,6 +267,8 @@ func loadQuestionsCSV() error {
 			if !ok {
 				subDim = shared.SubDimension{
 					Facets: make(map[string]shared.Facet),
+					Rank:   len(dimension.SubDimensions) + 1,
+					Slug:   shared.Slugify(question.SubDimension),
 				}
 			}
 			// add non-general to facets
@@ -102,6 +276,7 @@ func loadQuestionsCSV() error {
 			if !ok {
 				facet = shared.Facet{
 					Questions: []shared.Question{},
+					Slug:      shared.Slugify(question.Facet),
 				}
 			}
 			facet.Questions = append(facet.Questions, question)
@@ -112,12 +287,11 @@ func loadQuestionsCSV() error {
 
 		dimensions[question.Dimension] = dimension
 	}
-	return nil
 }
 
 func GetNextQuestions(userAnswers db.UserAnswers, prioDimension string) ([]shared.Question, error) {
 
-	var sortedDimQ []shared.CatVal
+	var sortedDimNames []string
 	if prioDimension == "" {
 		// Are general dimension questions answered
 		for _, v := range dimensionQuestions {
@@ -127,19 +301,17 @@ func GetNextQuestions(userAnswers db.UserAnswers, prioDimension string) ([]share
 		}
 
 		// All general dimension questions answered, sort them
-		sortedDimQ = userAnswers.SortByDimension(dimensionQuestions, GetDimensions())
+		for _, dim := range scoring.Compute(userAnswers, dimensionQuestions, GetDimensions()).SortedDimensions() {
+			sortedDimNames = append(sortedDimNames, dim.Name)
+		}
 	} else {
-		sortedDimQ = []shared.CatVal{{
-			CatType: shared.DimensionType,
-			Name:    prioDimension,
-			Value:   5, // TODO, weird that I need to set this here
-		}}
+		sortedDimNames = []string{prioDimension}
 	}
 
 	// start with the lowest Dimension
-	for _, dim := range sortedDimQ {
+	for _, dimName := range sortedDimNames {
 
-		currentDimension := GetDimensions()[dim.Name]
+		currentDimension := GetDimensions()[dimName]
 
 		// Are general subdimension questions answered?
 		for _, v := range currentDimension.GeneralQuestions {
@@ -149,8 +321,7 @@ func GetNextQuestions(userAnswers db.UserAnswers, prioDimension string) ([]share
 		}
 
 		// Send all questions from the first unanswered subdimension, if available
-		subDim := currentDimension.SubDimensions
-		for _, sd := range subDim {
+		for _, sd := range sortedSubDimensions(currentDimension) {
 			allAnswered := true
 			subDimQs := []shared.Question{}
 			for _, facets := range sd.Facets {
@@ -169,14 +340,32 @@ func GetNextQuestions(userAnswers db.UserAnswers, prioDimension string) ([]share
 	return []shared.Question{}, nil
 }
 
+// NextDimension returns the dimension GetNextQuestions picks next, or an empty
+// string while the general questions of all dimensions are still open or
+// everything is answered.
+func NextDimension(userAnswers db.UserAnswers) string {
+	next, err := GetNextQuestions(userAnswers, "")
+	if err != nil || len(next) == 0 || next[0].SubDimension == shared.GENERAL {
+		return ""
+	}
+	return next[0].Dimension
+}
+
+// sortedSubDimensions returns the sub-dimensions in questionnaire order.
+func sortedSubDimensions(dimension shared.Dimension) []shared.SubDimension {
+	subDims := slices.Collect(maps.Values(dimension.SubDimensions))
+	slices.SortFunc(subDims, func(a, b shared.SubDimension) int {
+		return a.Rank - b.Rank
+	})
+	return subDims
+}
+
 func GetCompleteDimensions(ua db.UserAnswers) []string {
 
-	// create copy of dimensions map
+	// create copy of dimensions map with dimensions that get insights
 	dims := make(map[string]shared.Dimension)
 	for k, v := range dimensions {
-		//TODO temporarily remove dimensions without questions
-		if k != "Happiness & Life Satisfaction" &&
-			k != "Character & Virtue" {
+		if dimensionConfigs[k].Insights {
 			dims[k] = v
 		}
 	}
@@ -210,8 +399,22 @@ func GetDimensionQuestions() []shared.Question {
 	return copy
 }
 
-// IsValidDimension checks if the given dimension name exists in dimensionOrder
+// IsValidDimension checks if a dimension with the given name or slug exists
+// in the loaded questions
 func IsValidDimension(dimensionName string) bool {
-	_, exists := dimensionOrder[dimensionName]
+	_, exists := ResolveDimension(dimensionName)
 	return exists
 }
+
+// ResolveDimension returns the name of the dimension with the given name or slug.
+func ResolveDimension(nameOrSlug string) (string, bool) {
+	if _, ok := dimensions[nameOrSlug]; ok {
+		return nameOrSlug, true
+	}
+	for name, dimension := range dimensions {
+		if dimension.Slug == nameOrSlug {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -1,6 +1,7 @@
 package questions_test
 
 import (
+	"slices"
 	"testing"
 	"user-db/db"
 	"user-db/questions"
@@ -188,3 +189,218 @@ func qsWithID(got []shared.Question, wantID int) bool {
 	}
 	return false
 }
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		wantIDs []int
+		wantErr bool
+	}{
+		{
+			name:    "ids-independent-of-row",
+			csv:     "7,Spirituality,general,general,Question A,min,max,need answer,\n3,Spirituality,general,general,Question B,min,max,need answer,\n",
+			wantIDs: []int{7, 3},
+		},
+		{
+			name:    "missing-policy-is-a-warning",
+			csv:     "7,Spirituality,general,general,Question A,min,max,,\n",
+			wantIDs: []int{7},
+		},
+		{
+			name:    "duplicate-id",
+			csv:     "7,Spirituality,general,general,Question A,min,max,need answer,\n7,Spirituality,general,general,Question B,min,max,need answer,\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing-id",
+			csv:     "Spirituality,general,general,Question A,min,max,need answer,,\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := questions.ParseCSV([]byte(tt.csv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i, id := range tt.wantIDs {
+				if got[i].ID != id {
+					t.Errorf("ParseCSV() question %d has ID %d, want %d", i, got[i].ID, id)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrationMapping(t *testing.T) {
+	legacy, err := questions.ParseLegacyCSV([]byte(
+		"Spirituality,general,general,Question A,min,max,need answer,\n" +
+			"Spirituality,general,general,Question B,min,max,need answer,\n" +
+			"Spirituality,general,general,Removed,min,max,need answer,\n"))
+	if err != nil {
+		t.Fatalf("ParseLegacyCSV() failed: %v", err)
+	}
+	current, err := questions.ParseCSV([]byte(
+		"2,Spirituality,general,general,Question B,min,max,need answer,\n" +
+			"1,Spirituality,general,general,Question A,min,max,need answer,\n"))
+	if err != nil {
+		t.Fatalf("ParseCSV() failed: %v", err)
+	}
+
+	mapping, unmatched := questions.MigrationMapping(legacy, current)
+	if mapping[1] != 1 || mapping[2] != 2 || len(mapping) != 2 {
+		t.Errorf("MigrationMapping() = %v", mapping)
+	}
+	if len(unmatched) != 1 || unmatched[0].Text != "Removed" {
+		t.Errorf("MigrationMapping() unmatched = %v", unmatched)
+	}
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name      string
+		csv       string
+		wantLines []int
+	}{
+		{
+			name: "valid",
+			csv:  "1,Spirituality,general,general,Question A,min,max,need answer,\n2,Spirituality,general,general,Question B,min,max,don't know,comment\n",
+		},
+		{
+			name: "all-issues-reported",
+			csv: "1,Spirituality,general,general,Question A,min,max,need answer,\n" +
+				"2,Spiritualty,general,general,Question B,min,max,need answer,\n" +
+				"3,Spirituality,Awe,Connection ,Question C,min,max,need answer,\n" +
+				"4,Spirituality,general,general,Question A,min,,need answer,\n" +
+				"5,Spirituality,general,general,Question D,min,max,maybe,\n" +
+				"6,Spirituality,general,general,Question E,min,max\n" +
+				"1,Spirituality,general,general,Question F,min,max,need answer,\n",
+			wantLines: []int{2, 3, 4, 4, 5, 6, 7},
+		},
+		{
+			name:      "missing-policy",
+			csv:       "1,Spirituality,general,general,Question A,min,max,,\n",
+			wantLines: []int{1},
+		},
+		{
+			name: "same-slug",
+			csv: "1,Spirituality,Awe,Guiding Beliefs,Question A,min,max,need answer,\n" +
+				"2,Spirituality,Awe,Guiding-Beliefs,Question B,min,max,need answer,\n",
+			wantLines: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := questions.Lint([]byte(tt.csv))
+			var gotLines []int
+			for _, issue := range issues {
+				gotLines = append(gotLines, issue.Line)
+			}
+			if !slices.Equal(gotLines, tt.wantLines) {
+				t.Errorf("Lint() = %v, want issues on lines %v", issues, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestLint_EmbeddedCSV(t *testing.T) {
+	for _, issue := range questions.Lint(questions.EmbeddedCSV()) {
+		if issue.Warning {
+			t.Logf("questions.csv %s", issue)
+			continue
+		}
+		t.Errorf("questions.csv %s", issue)
+	}
+}
+
+func TestResolveDimension(t *testing.T) {
+	tests := []struct {
+		nameOrSlug string
+		want       string
+		wantOk     bool
+	}{
+		{"Meaning & Purpose", "Meaning & Purpose", true},
+		{"meaning-purpose", "Meaning & Purpose", true},
+		{"happiness-life-satisfaction", "Happiness & Life Satisfaction", true},
+		{"meaning", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nameOrSlug, func(t *testing.T) {
+			got, ok := questions.ResolveDimension(tt.nameOrSlug)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("ResolveDimension() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGetCatalogue(t *testing.T) {
+	catalogue := questions.GetCatalogue()
+
+	if len(catalogue) != len(questions.GetDimensions()) {
+		t.Fatalf("GetCatalogue() has %d dimensions, want %d", len(catalogue), len(questions.GetDimensions()))
+	}
+	total := 0
+	for i, dim := range catalogue {
+		total += dim.QuestionCount
+		if i > 0 && catalogue[i-1].Rank > dim.Rank {
+			t.Errorf("GetCatalogue() %s not ordered by rank", dim.Name)
+		}
+		subDimTotal := 0
+		for _, subDim := range dim.SubDimensions {
+			subDimTotal += subDim.QuestionCount
+		}
+		// the rest are the general questions of the dimension
+		if subDimTotal >= dim.QuestionCount {
+			t.Errorf("GetCatalogue() %s has %d questions, its sub-dimensions %d", dim.Name, dim.QuestionCount, subDimTotal)
+		}
+	}
+	if total != len(questions.GetQuestions()) {
+		t.Errorf("GetCatalogue() counts %d questions, want %d", total, len(questions.GetQuestions()))
+	}
+}
+
+func TestGetCompleteDimensions(t *testing.T) {
+	ua := db.UserAnswers{Answers: test.AllAnswers5And([]int{})}
+
+	got := questions.GetCompleteDimensions(ua)
+	slices.Sort(got)
+
+	var want []string
+	for name := range questions.GetDimensions() {
+		if config, _ := questions.GetDimensionConfig(name); config.Insights {
+			want = append(want, name)
+		}
+	}
+	slices.Sort(want)
+
+	if !slices.Equal(got, want) {
+		t.Errorf("GetCompleteDimensions() = %v, want %v", got, want)
+	}
+	if slices.Contains(got, "Happiness & Life Satisfaction") {
+		t.Errorf("GetCompleteDimensions() contains a dimension without insights")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	store := db.NewMemoryStore()
+	embedded, err := questions.ParseCSV(questions.EmbeddedCSV())
+	if err != nil {
+		t.Fatalf("ParseCSV() failed: %v", err)
+	}
+	if _, err := store.ImportQuestions(embedded[:10]); err != nil {
+		t.Fatalf("ImportQuestions() failed: %v", err)
+	}
+	t.Cleanup(func() { questions.Load("embedded", store) })
+
+	if err := questions.Load("store", store); err != nil || questions.Revision() != 1 || len(questions.GetQuestions()) != 10 {
+		t.Errorf("Load(store) = %v, revision %d with %d questions", err, questions.Revision(), len(questions.GetQuestions()))
+	}
+	if err := questions.Load("embedded", store); err != nil || questions.Revision() != 0 || len(questions.GetQuestions()) != len(embedded) {
+		t.Errorf("Load(embedded) = %v, revision %d with %d questions", err, questions.Revision(), len(questions.GetQuestions()))
+	}
+	if err := questions.Load("file", store); err == nil {
+		t.Error("Load() with unknown source succeeded")
+	}
+}
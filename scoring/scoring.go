@@ -0,0 +1,236 @@
+package scoring
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"user-db/db"
+	"user-db/shared"
+)
+
+type Confidence string
+
+const (
+	// no answer with a value, the score is meaningless
+	NONE   Confidence = "NONE"
+	LOW    Confidence = "LOW"
+	MEDIUM Confidence = "MEDIUM"
+	HIGH   Confidence = "HIGH"
+)
+
+// Score summarises the answers to a group of questions. Value is the mean of
+// all SCALE answers; DONTKNOW and missing answers are counted but not scored.
+type Score struct {
+	Name         string     `json:"name"`
+	Value        float64    `json:"value"`
+	Answered     int        `json:"answered"`
+	DontKnow     int        `json:"dontKnow"`
+	Total        int        `json:"total"`
+	Completeness float64    `json:"completeness"`
+	Confidence   Confidence `json:"confidence"`
+}
+
+type FacetScore struct {
+	Score
+	SubDimension string `json:"subDimension"`
+}
+
+type SubDimensionScore struct {
+	Score
+	Rank   int          `json:"rank"`
+	Facets []FacetScore `json:"facets"`
+}
+
+type DimensionScore struct {
+	Score
+	Rank          int                 `json:"rank"`
+	SubDimensions []SubDimensionScore `json:"subDimensions"`
+}
+
+// Result holds the scores of all dimensions, ordered by rank.
+type Result struct {
+	Dimensions []DimensionScore `json:"dimensions"`
+}
+
+// Compute scores every dimension, sub-dimension and facet of the given
+// questions. Ranks are taken from dims.
+func Compute(ua db.UserAnswers, qs []shared.Question, dims map[string]shared.Dimension) Result {
+
+	// group questions by dimension, sub-dimension and facet
+	byDim := map[string][]shared.Question{}
+	bySubDim := map[string]map[string][]shared.Question{}
+	byFacet := map[string]map[string]map[string][]shared.Question{}
+	for _, q := range qs {
+		byDim[q.Dimension] = append(byDim[q.Dimension], q)
+		if q.SubDimension == shared.GENERAL {
+			continue
+		}
+		if bySubDim[q.Dimension] == nil {
+			bySubDim[q.Dimension] = map[string][]shared.Question{}
+			byFacet[q.Dimension] = map[string]map[string][]shared.Question{}
+		}
+		bySubDim[q.Dimension][q.SubDimension] = append(bySubDim[q.Dimension][q.SubDimension], q)
+		if q.Facet == shared.GENERAL {
+			continue
+		}
+		if byFacet[q.Dimension][q.SubDimension] == nil {
+			byFacet[q.Dimension][q.SubDimension] = map[string][]shared.Question{}
+		}
+		byFacet[q.Dimension][q.SubDimension][q.Facet] = append(byFacet[q.Dimension][q.SubDimension][q.Facet], q)
+	}
+
+	var result Result
+	for dimName, dimQs := range byDim {
+		dim := DimensionScore{
+			Score:         score(ua, dimName, dimQs),
+			Rank:          dims[dimName].Rank,
+			SubDimensions: []SubDimensionScore{},
+		}
+		for subDimName, subDimQs := range bySubDim[dimName] {
+			subDim := SubDimensionScore{
+				Score:  score(ua, subDimName, subDimQs),
+				Rank:   dims[dimName].SubDimensions[subDimName].Rank,
+				Facets: []FacetScore{},
+			}
+			for facetName, facetQs := range byFacet[dimName][subDimName] {
+				subDim.Facets = append(subDim.Facets, FacetScore{
+					Score:        score(ua, facetName, facetQs),
+					SubDimension: subDimName,
+				})
+			}
+			slices.SortFunc(subDim.Facets, func(a, b FacetScore) int {
+				return strings.Compare(a.Name, b.Name)
+			})
+			dim.SubDimensions = append(dim.SubDimensions, subDim)
+		}
+		slices.SortFunc(dim.SubDimensions, func(a, b SubDimensionScore) int {
+			return cmp.Or(cmp.Compare(a.Rank, b.Rank), strings.Compare(a.Name, b.Name))
+		})
+		result.Dimensions = append(result.Dimensions, dim)
+	}
+	slices.SortFunc(result.Dimensions, func(a, b DimensionScore) int {
+		return cmp.Or(cmp.Compare(a.Rank, b.Rank), strings.Compare(a.Name, b.Name))
+	})
+
+	return result
+}
+
+func score(ua db.UserAnswers, name string, qs []shared.Question) Score {
+	s := Score{Name: name, Total: len(qs)}
+	var sum int
+	for _, q := range qs {
+		answer := ua.GetLatestAnswer(q.ID)
+		switch {
+		case answer == nil || answer.Value == nil && !answer.IsDontKnow():
+			// not answered
+		case answer.IsDontKnow():
+			s.DontKnow++
+		default:
+			s.Answered++
+			sum += *answer.Value
+		}
+	}
+	if s.Answered > 0 {
+		s.Value = float64(sum) / float64(s.Answered)
+	}
+	if s.Total > 0 {
+		s.Completeness = float64(s.Answered+s.DontKnow) / float64(s.Total)
+	}
+	s.Confidence = confidence(s)
+	return s
+}
+
+// confidence rates how much of a score is backed by actual values.
+func confidence(s Score) Confidence {
+	if s.Answered == 0 {
+		return NONE
+	}
+	scored := float64(s.Answered) / float64(s.Total)
+	switch {
+	case scored >= 0.8:
+		return HIGH
+	case scored >= 0.5:
+		return MEDIUM
+	default:
+		return LOW
+	}
+}
+
+// IsScored reports whether at least one answer has a value.
+func (s Score) IsScored() bool {
+	return s.Answered > 0
+}
+
+func (s Score) String() string {
+	return fmt.Sprintf("%s: %.1f", s.Name, s.Value)
+}
+
+// Dimension returns the score of a dimension.
+func (r Result) Dimension(name string) (DimensionScore, bool) {
+	for _, d := range r.Dimensions {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return DimensionScore{}, false
+}
+
+// SortedDimensions returns all scored dimensions, lowest score first. Equal
+// scores are ordered by rank.
+func (r Result) SortedDimensions() []DimensionScore {
+	var sorted []DimensionScore
+	for _, d := range r.Dimensions {
+		if d.IsScored() {
+			sorted = append(sorted, d)
+		}
+	}
+	slices.SortStableFunc(sorted, func(a, b DimensionScore) int {
+		return cmp.Or(cmp.Compare(a.Value, b.Value), cmp.Compare(a.Rank, b.Rank))
+	})
+	return sorted
+}
+
+// SortedFacets returns all scored facets, lowest score first. Equal scores
+// are ordered by sub-dimension and facet name.
+func (r Result) SortedFacets() []FacetScore {
+	var sorted []FacetScore
+	for _, d := range r.Dimensions {
+		for _, sd := range d.SubDimensions {
+			for _, f := range sd.Facets {
+				if f.IsScored() {
+					sorted = append(sorted, f)
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(sorted, func(a, b FacetScore) int {
+		return cmp.Or(cmp.Compare(a.Value, b.Value), strings.Compare(a.FullName(), b.FullName()))
+	})
+	return sorted
+}
+
+// FullName returns the facet prefixed with its sub-dimension, e.g. "Sleep.alertness".
+func (f FacetScore) FullName() string {
+	return f.SubDimension + "." + f.Name
+}
+
+// RatingsToString describes the sub-dimension and facet scores of a dimension
+// for the dimension prompt.
+func (d DimensionScore) RatingsToString() string {
+	var sb strings.Builder
+	for _, sd := range d.SubDimensions {
+		sb.WriteString(ratingToString(sd.Score) + "\n")
+		for _, f := range sd.Facets {
+			sb.WriteString("  " + ratingToString(f.Score) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+func ratingToString(s Score) string {
+	if !s.IsScored() {
+		return s.Name + ": not rated"
+	}
+	return s.String()
+}
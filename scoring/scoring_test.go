@@ -0,0 +1,167 @@
+package scoring_test
+
+import (
+	"fmt"
+	"testing"
+	"user-db/db"
+	"user-db/questions"
+	"user-db/scoring"
+	"user-db/shared"
+	"user-db/test"
+)
+
+func TestResult_Sorted(t *testing.T) {
+	tests := []struct {
+		name string // description of this test case
+		// Named input parameters for receiver constructor.
+		userID string
+		// Named input parameters for target function.
+		userAnswers db.UserAnswers
+		qs          map[int]shared.Question
+		dims        map[string]shared.Dimension
+		want        []string
+		want2       []string
+	}{
+		{
+			name:   "all-answers",
+			userID: "all-answers",
+			userAnswers: db.UserAnswers{
+				UserID:  "all-answers-5",
+				Answers: test.AllAnswers5And([]int{}),
+			},
+			qs:   questions.GetQuestions(),
+			dims: questions.GetDimensions(),
+			want: []string{"Habits", "Physical Health", "Mental Health", "Social Relationships", "Character & Virtue", "Meaning & Purpose", "Spirituality", "Material Stability", "Happiness & Life Satisfaction"},
+			// equal ratings are ordered by name
+			want2: []string{"Action Control.Initiation Control",
+				"Action Control.Recovery Control",
+				"Activity.Aerobic",
+				"Activity.Sedentary Behaviour",
+				"Activity.Strength",
+				"Awe & Transcendence.Connection",
+				"Awe & Transcendence.Contemplation",
+				"Awe & Transcendence.Guiding Beliefs",
+				"Awe & Transcendence.Wonder",
+				"Boundaries.Assertive Limit-Setting",
+				"Boundaries.Emotional Boundaries",
+				"Boundaries.Enforcing Boundaries",
+				"Boundaries.Personal Autonomy",
+				"Cognitive Control.Goal Maintenance",
+				"Cognitive Control.Inhibitory Control",
+				"Cognitive Control.Sustained Attention",
+				"Communication.Active Listening and Empathy",
+				"Communication.Open and Honest Expression",
+				"Connection.Belonging",
+				"Connection.Emotional Support",
+				"Connection.Social Integration",
+				"Context.Cue Control",
+				"Context.Routine Stability",
+				"Emotion Regulation.Acceptance",
+				"Emotion Regulation.Awareness & Labeling",
+				"Emotion Regulation.Reappraisal",
+				"Financial Planning.Cashflow Plan & Tracking",
+				"Financial Planning.Debt Management",
+				"Financial Planning.Liquidity",
+				"Financial Planning.Payments Reliability",
+				"Financial Planning.Saving & Investing",
+				"Habits.Reflection",
+				"Habits.Self-Efficacy",
+				"Habits.Value Alignment",
+				"Sleep.Sleep quality",
+				"Sleep.alertness",
+				"Sleep.circadian rhythm",
+				"Values & Authenticity.Courageous Authenticity",
+				"Values & Authenticity.Identity Coherence",
+				"Values & Authenticity.Values Clarity",
+				"Values & Authenticity.Values–Action Congruence"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			result := scoring.Compute(tt.userAnswers, shared.MapToSlice(tt.qs), tt.dims)
+			var got, got2 []string
+			for _, d := range result.SortedDimensions() {
+				got = append(got, d.Name)
+			}
+			for _, f := range result.SortedFacets() {
+				got2 = append(got2, f.FullName())
+			}
+			if err := compareNames(got, tt.want); err != nil {
+				t.Errorf("SortedDimensions() = %v", err)
+			}
+			if err := compareNames(got2, tt.want2); err != nil {
+				t.Errorf("SortedFacets() = %v", err)
+			}
+		})
+	}
+}
+
+func compareNames(got []string, want []string) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("got %d names, want %d", len(got), len(want))
+	}
+	for i, v := range got {
+		if v != want[i] {
+			return fmt.Errorf("%s unequal to %s", v, want[i])
+		}
+	}
+	return nil
+}
+
+func TestCompute(t *testing.T) {
+	qs := []shared.Question{
+		{ID: 1, Dimension: "Mental Health", SubDimension: shared.GENERAL, Facet: shared.GENERAL},
+		{ID: 2, Dimension: "Mental Health", SubDimension: "Emotion Regulation", Facet: shared.GENERAL},
+		{ID: 3, Dimension: "Mental Health", SubDimension: "Emotion Regulation", Facet: "Acceptance"},
+		{ID: 4, Dimension: "Mental Health", SubDimension: "Emotion Regulation", Facet: "Acceptance"},
+		{ID: 5, Dimension: "Mental Health", SubDimension: "Emotion Regulation", Facet: "Reappraisal"},
+		{ID: 6, Dimension: "Spirituality", SubDimension: shared.GENERAL, Facet: shared.GENERAL},
+	}
+	answer := func(kind shared.AnswerKind, v int) db.QuestionAnswers {
+		return db.QuestionAnswers{LatestAnswer: db.AnswerEvent{Kind: kind.String(), Value: &v}}
+	}
+	ua := db.UserAnswers{
+		Answers: map[int]db.QuestionAnswers{
+			1: answer(shared.SCALE, 8),
+			2: answer(shared.SCALE, 5),
+			3: answer(shared.SCALE, 6),
+			// placeholder value must not be scored
+			4: answer(shared.DONTKNOW, 0),
+			// 5 and 6 are not answered
+		},
+	}
+
+	result := scoring.Compute(ua, qs, map[string]shared.Dimension{})
+
+	mental, ok := result.Dimension("Mental Health")
+	if !ok {
+		t.Fatalf("Dimension(Mental Health) not found")
+	}
+	want := scoring.Score{Name: "Mental Health", Value: 19.0 / 3, Answered: 3, DontKnow: 1, Total: 5, Completeness: 0.8, Confidence: scoring.MEDIUM}
+	if mental.Score != want {
+		t.Errorf("Mental Health = %+v, want %+v", mental.Score, want)
+	}
+
+	facets := mental.SubDimensions[0].Facets
+	if len(facets) != 2 {
+		t.Fatalf("got %d facets, want 2", len(facets))
+	}
+	if facets[0].Name != "Acceptance" || facets[0].Value != 6 || facets[0].Confidence != scoring.MEDIUM {
+		t.Errorf("Acceptance = %+v", facets[0])
+	}
+	if facets[1].Name != "Reappraisal" || facets[1].IsScored() || facets[1].Confidence != scoring.NONE {
+		t.Errorf("Reappraisal = %+v", facets[1])
+	}
+
+	spirituality, _ := result.Dimension("Spirituality")
+	if spirituality.IsScored() || spirituality.Completeness != 0 {
+		t.Errorf("Spirituality = %+v, want unscored", spirituality.Score)
+	}
+	if sorted := result.SortedDimensions(); len(sorted) != 1 || sorted[0].Name != "Mental Health" {
+		t.Errorf("SortedDimensions() = %v, want only scored dimensions", sorted)
+	}
+	if sorted := result.SortedFacets(); len(sorted) != 1 || sorted[0].FullName() != "Emotion Regulation.Acceptance" {
+		t.Errorf("SortedFacets() = %v, want only scored facets", sorted)
+	}
+}
@@ -10,6 +10,16 @@ import (
 type Config struct {
 	Environment string   `json:"environment"`
 	CorsOrigins []string `json:"cors_origins"`
+	// Store is "mongo" (default), which needs MONGODB_URI, or "memory"
+	Store string `json:"store"`
+	// Questions is "store" (default) for the imported question bank or
+	// "embedded" for the questions.csv of the binary
+	Questions string `json:"questions"`
+	// InsightGenerator is "openai" or "fake"; empty picks by OPENAI_API_KEY
+	InsightGenerator string `json:"insight_generator"`
+	// Broker is "memory" (default) for a single instance or "store" to fan
+	// out events through the store to all instances
+	Broker string `json:"broker"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -0,0 +1,103 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+type ChangeKind string
+
+const (
+	ADDED   ChangeKind = "ADDED"
+	REMOVED ChangeKind = "REMOVED"
+	CHANGED ChangeKind = "CHANGED"
+)
+
+// Change is a single difference between two JSON documents. Path is the
+// dotted location of the value, with array indices in brackets.
+type Change struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	From any        `json:"from,omitempty"`
+	To   any        `json:"to,omitempty"`
+}
+
+// DiffJSON returns the changes needed to get from document a to document b.
+// Objects are compared key by key and arrays index by index.
+func DiffJSON(a, b json.RawMessage) ([]Change, error) {
+	var from, to any
+	if err := json.Unmarshal(a, &from); err != nil {
+		return nil, fmt.Errorf("invalid JSON in first document: %w", err)
+	}
+	if err := json.Unmarshal(b, &to); err != nil {
+		return nil, fmt.Errorf("invalid JSON in second document: %w", err)
+	}
+	changes := []Change{}
+	diffValues("", from, to, &changes)
+	return changes, nil
+}
+
+func diffValues(path string, from, to any, changes *[]Change) {
+	switch f := from.(type) {
+	case map[string]any:
+		if t, ok := to.(map[string]any); ok {
+			diffObjects(path, f, t, changes)
+			return
+		}
+	case []any:
+		if t, ok := to.([]any); ok {
+			diffArrays(path, f, t, changes)
+			return
+		}
+	}
+	if !reflect.DeepEqual(from, to) {
+		*changes = append(*changes, Change{Path: path, Kind: CHANGED, From: from, To: to})
+	}
+}
+
+func diffObjects(path string, from, to map[string]any, changes *[]Change) {
+	keys := make([]string, 0, len(from)+len(to))
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		childPath := k
+		if path != "" {
+			childPath = path + "." + k
+		}
+		f, inFrom := from[k]
+		t, inTo := to[k]
+		switch {
+		case !inFrom:
+			*changes = append(*changes, Change{Path: childPath, Kind: ADDED, To: t})
+		case !inTo:
+			*changes = append(*changes, Change{Path: childPath, Kind: REMOVED, From: f})
+		default:
+			diffValues(childPath, f, t, changes)
+		}
+	}
+}
+
+func diffArrays(path string, from, to []any, changes *[]Change) {
+	for i := 0; i < max(len(from), len(to)); i++ {
+		childPath := path + "[" + strconv.Itoa(i) + "]"
+		switch {
+		case i >= len(from):
+			*changes = append(*changes, Change{Path: childPath, Kind: ADDED, To: to[i]})
+		case i >= len(to):
+			*changes = append(*changes, Change{Path: childPath, Kind: REMOVED, From: from[i]})
+		default:
+			diffValues(childPath, from[i], to[i], changes)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package shared_test
+
+import (
+	"reflect"
+	"testing"
+	"user-db/shared"
+)
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		want    []shared.Change
+		wantErr bool
+	}{
+		{
+			name: "equal",
+			a:    `{"summary":"x","tips":["a","b"]}`,
+			b:    `{"tips":["a","b"],"summary":"x"}`,
+			want: []shared.Change{},
+		},
+		{
+			name: "changed-added-removed",
+			a:    `{"summary":"old","score":3,"tips":["a","b"]}`,
+			b:    `{"summary":"new","focus":{"facet":"Sleep"},"tips":["a"]}`,
+			want: []shared.Change{
+				{Path: "focus", Kind: shared.ADDED, To: map[string]any{"facet": "Sleep"}},
+				{Path: "score", Kind: shared.REMOVED, From: 3.0},
+				{Path: "summary", Kind: shared.CHANGED, From: "old", To: "new"},
+				{Path: "tips[1]", Kind: shared.REMOVED, From: "b"},
+			},
+		},
+		{
+			name: "nested",
+			a:    `{"facets":[{"name":"Sleep","advice":"x"}]}`,
+			b:    `{"facets":[{"name":"Sleep","advice":"y"}]}`,
+			want: []shared.Change{
+				{Path: "facets[0].advice", Kind: shared.CHANGED, From: "x", To: "y"},
+			},
+		},
+		{
+			name:    "invalid",
+			a:       `{`,
+			b:       `{}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := shared.DiffJSON([]byte(tt.a), []byte(tt.b))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DiffJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package shared
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slugify turns a name into a URL-safe identifier, e.g. "Meaning & Purpose"
+// becomes "meaning-purpose". Everything but ASCII letters and digits
+// separates words.
+func Slugify(name string) string {
+	var sb strings.Builder
+	separate := false
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if separate && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			separate = false
+		} else {
+			separate = true
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,27 @@
+package shared_test
+
+import (
+	"testing"
+	"user-db/shared"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Meaning & Purpose", "meaning-purpose"},
+		{"Happiness & Life Satisfaction", "happiness-life-satisfaction"},
+		{"Values–Action Congruence", "values-action-congruence"},
+		{"Assertive Limit-Setting", "assertive-limit-setting"},
+		{" Reappraisal ", "reappraisal"},
+		{"circadian rhythm", "circadian-rhythm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shared.Slugify(tt.name); got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -5,58 +5,87 @@ import (
 )
 
 const GENERAL string = "general"
-const HABITS string = "Habits"
 
-type CatType int
+type Question struct {
+	ID           int          `json:"id"`
+	Text         string       `json:"text"`
+	MinLabel     string       `json:"min_label"`
+	MaxLabel     string       `json:"max_label"`
+	Dimension    string       `json:"dimension"`
+	SubDimension string       `json:"sub_dimension"`
+	Facet        string       `json:"facet"`
+	Policy       AnswerPolicy `json:"policy"`
+	ScaleMin     int          `json:"scale_min"`
+	ScaleMax     int          `json:"scale_max"`
+}
 
+// scale of all questions in questions.csv, as in the GF12 flourishing measure
 const (
-	DimensionType CatType = iota
-	FacetType
+	DefaultScaleMin = 0
+	DefaultScaleMax = 10
 )
 
-func (ct CatType) String() string {
-	switch ct {
-	case DimensionType:
-		return "Dimension"
-	case FacetType:
-		return "Facet"
-	default:
-		return "Unknown"
-	}
+// InScale reports whether value is a valid SCALE answer to the question.
+func (q Question) InScale(value int) bool {
+	return value >= q.ScaleMin && value <= q.ScaleMax
 }
 
-type CatVal struct {
-	CatType CatType // Dimension or Facet
-	Name    string
-	Value   int
-}
+// AnswerPolicy says whether a question may be answered with DONTKNOW.
+type AnswerPolicy string
 
-func (cv CatVal) ToString() string {
-	return fmt.Sprintf("%s: %d", cv.Name, cv.Value)
-}
+const (
+	NEEDANSWER   AnswerPolicy = "need answer"
+	MAYDONTKNOW  AnswerPolicy = "don't know"
+	DOESNOTAPPLY AnswerPolicy = "does not apply"
+)
 
-type Question struct {
-	ID           int    `json:"id"`
-	Text         string `json:"text"`
-	MinLabel     string `json:"min_label"`
-	MaxLabel     string `json:"max_label"`
-	Dimension    string `json:"dimension"`
-	SubDimension string `json:"sub_dimension"`
-	Facet        string `json:"facet"`
+var AnswerPolicies = []AnswerPolicy{NEEDANSWER, MAYDONTKNOW, DOESNOTAPPLY}
+
+func (ap AnswerPolicy) AllowsDontKnow() bool {
+	return ap == MAYDONTKNOW || ap == DOESNOTAPPLY
 }
 
 type Facet struct {
 	Questions []Question `json:"questions"`
+	Slug      string     `json:"slug"`
 }
 
 type Dimension struct {
 	SubDimensions    map[string]SubDimension `json:"sub_dimensions"`
 	GeneralQuestions []Question              `json:"general_questions"`
 	Rank             int                     `json:"rank,omitempty"`
+	Slug             string                  `json:"slug"`
+}
+
+// DimensionConfig is the entry of a dimension in questions/dimensions.json.
+type DimensionConfig struct {
+	Name string `json:"name"`
+	Rank int    `json:"rank"`
+	// questions of disabled dimensions are not asked
+	Enabled bool `json:"enabled"`
+	// whether an insight is generated once all questions are answered
+	Insights    bool         `json:"insights"`
+	Prompt      PromptConfig `json:"prompt"`
+	Label       string       `json:"label"`
+	Description string       `json:"description"`
 }
 
+// PromptConfig selects the prompt of a dimension insight.
+type PromptConfig struct {
+	// ID of the stored prompt, empty for the default dimension prompt
+	ID string `json:"id,omitempty"`
+	// Input is "ratings" to send only the ratings, empty to send the ratings
+	// with instructions to focus on the dimension
+	Input string `json:"input,omitempty"`
+}
+
+const RATINGS_INPUT string = "ratings"
+
 type SubDimension struct {
 	Facets map[string]Facet `json:"facets"`
+	Slug   string           `json:"slug"`
+	// Rank is the position of the sub-dimension within its dimension in the questionnaire
+	Rank int `json:"rank,omitempty"`
 }
 
 type AnswerKind string